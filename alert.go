@@ -0,0 +1,405 @@
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AlertConfig struct {
+	// Window is how long repeats of a key are suppressed after it is sent, 1h by default.
+	// The first notification after the window reports how many were suppressed.
+	// Keys are forgotten once their window is over, or a window later if
+	// repeats were suppressed and haven't been reported yet. A notification
+	// that fails to be sent doesn't start a window, so the next one is sent.
+	Window time.Duration
+
+	// EscalateAfter sends one escalation once a key has occurred this many times
+	// within its window. Zero disables escalation.
+	EscalateAfter int
+	// Escalation receives escalations, the Alerter's Notifier if nil.
+	Escalation Notifier
+	// EscalationSeverity is the minimum severity of escalations, Critical by default.
+	EscalationSeverity Severity
+
+	// Notifications below DigestBelow are batched and sent together every
+	// DigestInterval (1h by default). Zero disables digesting.
+	DigestBelow    Severity
+	DigestInterval time.Duration
+
+	// StatePath, if set, is a JSON file the state is loaded from and saved to,
+	// so suppression survives restarts. Notify saves changes at most every
+	// SaveInterval (10s by default); FlushDigest, Resolve and Save save them
+	// straight away.
+	StatePath    string
+	SaveInterval time.Duration
+}
+
+func (c AlertConfig) withDefaults() AlertConfig {
+	if c.Window <= 0 {
+		c.Window = time.Hour
+	}
+	if c.EscalationSeverity == 0 {
+		c.EscalationSeverity = Critical
+	}
+	if c.DigestInterval <= 0 {
+		c.DigestInterval = time.Hour
+	}
+	if c.SaveInterval <= 0 {
+		c.SaveInterval = 10 * time.Second
+	}
+
+	return c
+}
+
+// AlertState is what an Alerter remembers about a key.
+type AlertState struct {
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+	// Sent is when the key was last passed on; its window starts then.
+	Sent time.Time `json:"sent"`
+	// Count is the number of occurrences in the current window, Suppressed
+	// the number of those that weren't sent.
+	Count      int  `json:"count"`
+	Suppressed int  `json:"suppressed"`
+	Escalated  bool `json:"escalated,omitempty"`
+}
+
+type alertFile struct {
+	Keys       map[string]*AlertState `json:"keys"`
+	Digest     []Notification         `json:"digest,omitempty"`
+	DigestSent time.Time              `json:"digestSent,omitzero"`
+}
+
+// Alerter deduplicates, escalates and digests notifications before passing
+// them on to another Notifier. Notifications are grouped by Key, or by
+// Subject if they have none.
+type Alerter struct {
+	next Notifier
+	cfg  AlertConfig
+
+	mu     sync.Mutex
+	state  alertFile
+	pruned time.Time
+	// version counts changes to state, saved the last one saved
+	version, saved int
+	savedAt        time.Time
+
+	// held while writing StatePath, written the version last written
+	fileMu  sync.Mutex
+	written int
+
+	now func() time.Time
+}
+
+func NewAlerter(next Notifier, cfg AlertConfig) (*Alerter, error) {
+	a := &Alerter{
+		next:  next,
+		cfg:   cfg.withDefaults(),
+		state: alertFile{Keys: make(map[string]*AlertState)},
+		now:   time.Now,
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	if a.state.DigestSent.IsZero() {
+		a.state.DigestSent = a.now()
+	}
+	a.pruned = a.now()
+	return a, nil
+}
+
+func (a *Alerter) Notify(ctx context.Context, n Notification) error {
+	now := a.now()
+	if n.Time.IsZero() {
+		n.Time = now
+	}
+	key := Coalesce(n.Key, n.Subject)
+
+	a.mu.Lock()
+	var send []func() error
+	a.version++
+	a.prune(now)
+
+	if a.cfg.DigestBelow > 0 && n.Severity < a.cfg.DigestBelow {
+		a.state.Digest = append(a.state.Digest, n)
+	} else {
+		st, existed := a.state.Keys[key]
+		if !existed {
+			st = &AlertState{First: now}
+			a.state.Keys[key] = st
+		}
+		st.Last = now
+
+		if st.Sent.IsZero() || now.Sub(st.Sent) >= a.cfg.Window {
+			if st.Suppressed > 0 {
+				n.Fields = withField(n.Fields, "suppressed", st.Suppressed)
+			}
+			prev := *st
+			st.Sent, st.Count, st.Suppressed, st.Escalated = now, 1, 0, false
+			send = append(send, a.deliver(ctx, a.next, n, func() {
+				if a.state.Keys[key] != st {
+					return
+				}
+				if !existed {
+					delete(a.state.Keys, key)
+					return
+				}
+				prev.Last = st.Last
+				*st = prev
+			}))
+		} else {
+			st.Count++
+			st.Suppressed++
+			if a.cfg.EscalateAfter > 0 && st.Count >= a.cfg.EscalateAfter && !st.Escalated {
+				st.Escalated = true
+				esc := a.escalation(n, st)
+				to := a.next
+				if a.cfg.Escalation != nil {
+					to = a.cfg.Escalation
+				}
+				send = append(send, a.deliver(ctx, to, esc, func() {
+					if a.state.Keys[key] == st {
+						st.Escalated = false
+					}
+				}))
+			}
+		}
+	}
+
+	if now.Sub(a.state.DigestSent) >= a.cfg.DigestInterval {
+		if d, undo, ok := a.takeDigest(now); ok {
+			send = append(send, a.deliver(ctx, a.next, d, undo))
+		}
+	}
+	a.mu.Unlock()
+
+	var err error
+	for _, fn := range send {
+		err = errors.Join(err, fn())
+	}
+	return errors.Join(err, a.save(false))
+}
+
+// deliver returns a func sending n to to. If that fails, undo (called with
+// a.mu held) reverts the state changed for n, so it is sent again later.
+func (a *Alerter) deliver(ctx context.Context, to Notifier, n Notification, undo func()) func() error {
+	return func() error {
+		err := to.Notify(ctx, n)
+		if err != nil {
+			a.mu.Lock()
+			undo()
+			a.version++
+			a.mu.Unlock()
+		}
+		return err
+	}
+}
+
+func (a *Alerter) escalation(n Notification, st *AlertState) Notification {
+	n.Subject = "[escalated] " + n.Subject
+	n.Severity = max(n.Severity, a.cfg.EscalationSeverity)
+	n.Fields = withField(n.Fields, "occurrences", st.Count)
+	n.Fields = withField(n.Fields, "since", st.Sent.Format(time.RFC3339))
+	return n
+}
+
+// withField returns a copy of fields with k set to v.
+func withField(fields map[string]any, k string, v any) map[string]any {
+	m := make(map[string]any, len(fields)+1)
+	for fk, fv := range fields {
+		m[fk] = fv
+	}
+	m[k] = v
+	return m
+}
+
+// FlushDigest sends the pending digest now, if there is one. If that fails,
+// the notifications are kept for the next digest.
+func (a *Alerter) FlushDigest(ctx context.Context) error {
+	a.mu.Lock()
+	d, undo, ok := a.takeDigest(a.now())
+	a.version++
+	a.mu.Unlock()
+
+	var err error
+	if ok {
+		err = a.deliver(ctx, a.next, d, undo)()
+	}
+	return errors.Join(err, a.save(true))
+}
+
+// Run flushes the digest every DigestInterval until ctx is done.
+func (a *Alerter) Run(ctx context.Context) error {
+	t := time.NewTicker(a.cfg.DigestInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			if err := a.FlushDigest(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// takeDigest combines the pending notifications into one, with a line per
+// key, and resets the digest; undo puts them back. It must be called with
+// a.mu held.
+func (a *Alerter) takeDigest(now time.Time) (d Notification, undo func(), ok bool) {
+	pending, sent := a.state.Digest, a.state.DigestSent
+	a.state.Digest = nil
+	a.state.DigestSent = now
+	if len(pending) == 0 {
+		return Notification{}, nil, false
+	}
+	undo = func() {
+		a.state.Digest = append(pending, a.state.Digest...)
+		a.state.DigestSent = sent
+	}
+
+	var (
+		order  []string
+		counts = make(map[string]int)
+		latest = make(map[string]Notification)
+		sev    Severity
+	)
+	for _, n := range pending {
+		key := Coalesce(n.Key, n.Subject)
+		if _, ok := counts[key]; !ok {
+			order = append(order, key)
+		}
+		counts[key]++
+		latest[key] = n
+		sev = max(sev, n.Severity)
+	}
+
+	var b strings.Builder
+	for _, key := range order {
+		n := latest[key]
+		b.WriteString(n.Time.Format(time.DateTime) + " " + n.Severity.String() + " " + n.Subject)
+		if counts[key] > 1 {
+			b.WriteString(fmt.Sprintf(" (x%d)", counts[key]))
+		}
+		b.WriteByte('\n')
+	}
+
+	return Notification{
+		Subject:  fmt.Sprintf("Digest: %d %s", len(pending), If(len(pending) == 1, "notification", "notifications")),
+		Body:     strings.TrimRight(b.String(), "\n"),
+		Severity: sev,
+		Key:      "digest",
+		Time:     now,
+	}, undo, true
+}
+
+// State returns a copy of the state of key.
+func (a *Alerter) State(key string) (AlertState, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st, ok := a.state.Keys[key]
+	if !ok {
+		return AlertState{}, false
+	}
+	return *st, true
+}
+
+// Resolve forgets key, so its next occurrence is sent straight away.
+func (a *Alerter) Resolve(key string) error {
+	a.mu.Lock()
+	delete(a.state.Keys, key)
+	a.version++
+	a.mu.Unlock()
+
+	return a.save(true)
+}
+
+// Save saves any unsaved changes to StatePath, e.g. before exiting.
+func (a *Alerter) Save() error {
+	return a.save(true)
+}
+
+// prune forgets keys whose window is over (see AlertConfig.Window). It runs
+// at most once a window and must be called with a.mu held.
+func (a *Alerter) prune(now time.Time) {
+	if now.Sub(a.pruned) < a.cfg.Window {
+		return
+	}
+	a.pruned = now
+	for key, st := range a.state.Keys {
+		age := now.Sub(st.Sent)
+		if age >= a.cfg.Window && st.Suppressed == 0 || age >= 2*a.cfg.Window {
+			delete(a.state.Keys, key)
+		}
+	}
+}
+
+func (a *Alerter) load() error {
+	if a.cfg.StatePath == "" {
+		return nil
+	}
+	b, err := os.ReadFile(a.cfg.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load alert state: %w", err)
+	}
+	if err := json.Unmarshal(b, &a.state); err != nil {
+		return fmt.Errorf("load alert state: %w", err)
+	}
+	if a.state.Keys == nil {
+		a.state.Keys = make(map[string]*AlertState)
+	}
+	return nil
+}
+
+// save writes the state to StatePath if it has changed since it was last
+// saved, and either force is set or SaveInterval has passed since then.
+// The file is written without holding a.mu.
+func (a *Alerter) save(force bool) error {
+	if a.cfg.StatePath == "" {
+		return nil
+	}
+
+	a.mu.Lock()
+	now := a.now()
+	if a.version == a.saved || !force && now.Sub(a.savedAt) < a.cfg.SaveInterval {
+		a.mu.Unlock()
+		return nil
+	}
+	version := a.version
+	b, err := json.Marshal(a.state)
+	if err == nil {
+		a.saved, a.savedAt = version, now
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("save alert state: %w", err)
+	}
+
+	a.fileMu.Lock()
+	defer a.fileMu.Unlock()
+	if version < a.written {
+		// a newer state has been written meanwhile
+		return nil
+	}
+	a.written = version
+	tmp := a.cfg.StatePath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("save alert state: %w", err)
+	}
+	if err := os.Rename(tmp, a.cfg.StatePath); err != nil {
+		return fmt.Errorf("save alert state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (r *recordNotifier) Notify(_ context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func (r *recordNotifier) subjects() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ret []string
+	for _, n := range r.sent {
+		ret = append(ret, n.Subject)
+	}
+	return ret
+}
+
+// failNotifier fails its first fail calls, then records like recordNotifier.
+type failNotifier struct {
+	recordNotifier
+	fail int
+}
+
+func (f *failNotifier) Notify(ctx context.Context, n Notification) error {
+	f.mu.Lock()
+	if f.fail > 0 {
+		f.fail--
+		f.mu.Unlock()
+		return errors.New("smtp down")
+	}
+	f.mu.Unlock()
+	return f.recordNotifier.Notify(ctx, n)
+}
+
+func TestAlerter_FailedSend(t *testing.T) {
+	out := &failNotifier{fail: 1}
+	pager := &failNotifier{fail: 1}
+	a, err := NewAlerter(out, AlertConfig{
+		Window:         time.Hour,
+		EscalateAfter:  2,
+		Escalation:     pager,
+		DigestBelow:    Warning,
+		DigestInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+	failed := Notification{Subject: "push failed", Severity: Error}
+
+	// a failed send doesn't start the window
+	require.ErrorContains(t, a.Notify(ctx, failed), "smtp down")
+	_, ok := a.State("push failed")
+	require.False(t, ok)
+	require.NoError(t, a.Notify(ctx, failed))
+	require.Equal(t, []string{"push failed"}, out.subjects())
+
+	// nor is a failed escalation marked as sent
+	require.ErrorContains(t, a.Notify(ctx, failed), "smtp down")
+	st, _ := a.State("push failed")
+	require.False(t, st.Escalated)
+	require.NoError(t, a.Notify(ctx, failed))
+	require.Equal(t, []string{"[escalated] push failed"}, pager.subjects())
+
+	// and a failed digest is kept for the next
+	out.fail = 1
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "slow", Severity: Notice}))
+	require.ErrorContains(t, a.FlushDigest(ctx), "smtp down")
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "slow", Severity: Notice}))
+	require.NoError(t, a.FlushDigest(ctx))
+	require.Equal(t, []string{"push failed", "Digest: 2 notifications"}, out.subjects())
+}
+
+func TestAlerter_DedupeAndEscalate(t *testing.T) {
+	var out, pager recordNotifier
+	a, err := NewAlerter(&out, AlertConfig{
+		Window:        10 * time.Minute,
+		EscalateAfter: 3,
+		Escalation:    &pager,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	ctx := context.Background()
+	failed := Notification{Subject: "push failed", Key: "push:orders", Severity: Error}
+
+	for range 4 {
+		require.NoError(t, a.Notify(ctx, failed))
+		now = now.Add(time.Minute)
+	}
+	require.Equal(t, []string{"push failed"}, out.subjects())
+	require.Equal(t, []string{"[escalated] push failed"}, pager.subjects())
+	require.Equal(t, Critical, pager.sent[0].Severity)
+	require.Equal(t, 3, pager.sent[0].Fields["occurrences"])
+
+	st, ok := a.State("push:orders")
+	require.True(t, ok)
+	require.Equal(t, 4, st.Count)
+	require.Equal(t, 3, st.Suppressed)
+
+	// a new window reports what was suppressed
+	now = now.Add(10 * time.Minute)
+	require.NoError(t, a.Notify(ctx, failed))
+	require.Len(t, out.sent, 2)
+	require.Equal(t, 3, out.sent[1].Fields["suppressed"])
+
+	// other keys are independent, and resolved keys start over
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "push failed", Key: "push:users", Severity: Error}))
+	require.NoError(t, a.Resolve("push:orders"))
+	require.NoError(t, a.Notify(ctx, failed))
+	require.Len(t, out.sent, 4)
+	require.Len(t, pager.sent, 1)
+}
+
+func TestAlerter_Digest(t *testing.T) {
+	var out recordNotifier
+	a, err := NewAlerter(&out, AlertConfig{DigestBelow: Warning, DigestInterval: time.Hour})
+	require.NoError(t, err)
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "slow load", Severity: Notice}))
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "slow load", Severity: Notice}))
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "retrying", Severity: Info}))
+	require.Empty(t, out.sent)
+
+	now = now.Add(time.Hour)
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "down", Severity: Error}))
+	require.Equal(t, []string{"down", "Digest: 3 notifications"}, out.subjects())
+
+	d := out.sent[1]
+	require.Equal(t, Notice, d.Severity)
+	require.Contains(t, d.Body, "NOTICE slow load (x2)\n")
+	require.Contains(t, d.Body, "INFO retrying")
+
+	require.NoError(t, a.FlushDigest(ctx))
+	require.Len(t, out.sent, 2)
+}
+
+func TestAlerter_State(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	var out recordNotifier
+	cfg := AlertConfig{Window: time.Hour, DigestBelow: Warning, StatePath: path}
+	ctx := context.Background()
+
+	a, err := NewAlerter(&out, cfg)
+	require.NoError(t, err)
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "push failed", Severity: Error}))
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "slow", Severity: Notice}))
+	// an unset severity is digested too
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "unset"}))
+
+	// Notify only saves every SaveInterval
+	b, err := NewAlerter(&out, cfg)
+	require.NoError(t, err)
+	require.Empty(t, b.state.Digest)
+	require.NoError(t, a.Save())
+
+	// a restarted process keeps suppressing and digesting
+	a, err = NewAlerter(&out, cfg)
+	require.NoError(t, err)
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "push failed", Severity: Error}))
+	require.Len(t, out.sent, 1)
+
+	require.NoError(t, a.FlushDigest(ctx))
+	require.Equal(t, []string{"push failed", "Digest: 2 notifications"}, out.subjects())
+	require.Contains(t, out.sent[1].Body, "DEFAULT unset")
+
+	a, err = NewAlerter(&out, cfg)
+	require.NoError(t, err)
+	require.Empty(t, a.state.Digest)
+}
+
+func TestAlerter_Prune(t *testing.T) {
+	var out recordNotifier
+	a, err := NewAlerter(&out, AlertConfig{Window: time.Hour})
+	require.NoError(t, err)
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "old"}))
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "recent"}))
+
+	now = now.Add(45 * time.Minute)
+	require.NoError(t, a.Notify(ctx, Notification{Subject: "new"}))
+	_, ok := a.State("old")
+	require.False(t, ok)
+	_, ok = a.State("recent")
+	require.True(t, ok)
+}
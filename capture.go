@@ -0,0 +1,217 @@
+package p
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+type CaptureMode int
+
+const (
+	// CaptureTail keeps the last Limit bytes.
+	CaptureTail CaptureMode = iota
+	// CaptureHead keeps the first Limit bytes.
+	CaptureHead
+	// CaptureHeadTail keeps the first and last Limit/2 bytes, with a marker
+	// saying how much was left out in between.
+	CaptureHeadTail
+)
+
+// CaptureConfig bounds the Stdout, Stderr and Combined buffers of a
+// Subprocess with Capture on. Limited buffers are filled in once the process exits.
+type CaptureConfig struct {
+	// Limit is the number of bytes kept in each buffer. Zero means no limit.
+	Limit int
+	Mode  CaptureMode
+	// Spill writes all of stdout and stderr to temp files (in SpillDir, or
+	// the default temp dir) once they exceed Limit. See SpillFiles and Cleanup.
+	Spill    bool
+	SpillDir string
+}
+
+type captureBuffer struct {
+	cfg   CaptureConfig
+	name  string
+	spill bool
+
+	buf        []byte // everything, until Limit is exceeded
+	overflowed bool
+	head       []byte
+	headLimit  int
+	tail       tailBuffer
+	total      int64
+
+	file *os.File
+	err  error
+}
+
+func newCaptureBuffer(cfg CaptureConfig, name string, spill bool) *captureBuffer {
+	c := &captureBuffer{cfg: cfg, name: name, spill: spill && cfg.Spill}
+	switch cfg.Mode {
+	case CaptureHead:
+		c.headLimit = cfg.Limit
+	case CaptureHeadTail:
+		c.headLimit = cfg.Limit / 2
+		c.tail.max = cfg.Limit - c.headLimit
+	default:
+		c.tail.max = cfg.Limit
+	}
+	return c
+}
+
+func (c *captureBuffer) Write(b []byte) (int, error) {
+	n := len(b)
+	c.total += int64(n)
+
+	if !c.overflowed {
+		if len(c.buf)+len(b) <= c.cfg.Limit {
+			c.buf = append(c.buf, b...)
+			return n, nil
+		}
+		c.overflowed = true
+		if c.spill {
+			c.startSpill()
+		}
+		c.keep(c.buf)
+		c.buf = nil
+	}
+
+	c.keep(b)
+	if c.file != nil && c.err == nil {
+		if _, err := c.file.Write(b); err != nil {
+			c.err = fmt.Errorf("spill %s: %w", c.name, err)
+		}
+	}
+	return n, nil
+}
+
+func (c *captureBuffer) keep(b []byte) {
+	if room := c.headLimit - len(c.head); room > 0 {
+		take := min(room, len(b))
+		c.head = append(c.head, b[:take]...)
+		b = b[take:]
+	}
+	if c.tail.max > 0 && len(b) > 0 {
+		c.tail.Write(b)
+	}
+}
+
+// startSpill creates the spill file with everything written before the overflow.
+func (c *captureBuffer) startSpill() {
+	f, err := os.CreateTemp(c.cfg.SpillDir, "subprocess-"+c.name+"-*")
+	if err == nil {
+		_, err = f.Write(c.buf)
+	}
+	if err != nil {
+		c.err = fmt.Errorf("spill %s: %w", c.name, err)
+		if f != nil {
+			f.Close()
+		}
+		return
+	}
+	c.file = f
+}
+
+// close finishes the spill file, returning its name if there is one.
+func (c *captureBuffer) close() (string, error) {
+	if c.file == nil {
+		return "", c.err
+	}
+	err := c.file.Close()
+	return c.file.Name(), errors.Join(c.err, err)
+}
+
+func (c *captureBuffer) String() string {
+	if !c.overflowed {
+		return string(c.buf)
+	}
+	switch c.cfg.Mode {
+	case CaptureHead:
+		return string(c.head)
+	case CaptureHeadTail:
+		elided := c.total - int64(len(c.head)+len(c.tail.buf))
+		return string(c.head) + Format("\n... [%d bytes elided] ...\n", elided) + c.tail.String()
+	}
+	return c.tail.String()
+}
+
+// SpillFiles returns the files output was spilled to (see CaptureConfig.Spill),
+// across every run so far.
+func (sp *Subprocess) SpillFiles() []string {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return append([]string(nil), sp.spillFiles...)
+}
+
+// Cleanup removes the spill files.
+func (sp *Subprocess) Cleanup() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	var errs []error
+	for _, name := range sp.spillFiles {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	sp.spillFiles = nil
+	return errors.Join(errs...)
+}
+
+// startCapture sets up limited capture buffers for a run, if there is a limit.
+func (sp *Subprocess) startCapture() {
+	sp.stdoutCap, sp.stderrCap, sp.combinedCap = nil, nil, nil
+	if !sp.Capture && !sp.captureStderr || sp.CaptureConfig.Limit <= 0 {
+		return
+	}
+	sp.stdoutCap = newCaptureBuffer(sp.CaptureConfig, "stdout", true)
+	sp.stderrCap = newCaptureBuffer(sp.CaptureConfig, "stderr", true)
+	sp.combinedCap = newCaptureBuffer(sp.CaptureConfig, "combined", false)
+}
+
+// capture records a token of output. It must be called with sp.mu held.
+func (sp *Subprocess) capture(stderr bool, s string) {
+	if sp.combinedCap == nil {
+		if stderr {
+			sp.Stderr.WriteString(s)
+		} else {
+			sp.Stdout.WriteString(s)
+		}
+		sp.Combined.WriteString(s)
+		return
+	}
+
+	c := sp.stdoutCap
+	if stderr {
+		c = sp.stderrCap
+	}
+	c.Write([]byte(s))
+	sp.combinedCap.Write([]byte(s))
+}
+
+// finishCapture fills the public buffers from limited ones and closes spill files.
+func (sp *Subprocess) finishCapture() error {
+	if sp.combinedCap == nil {
+		return nil
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	var errs []error
+	for _, c := range []struct {
+		cap *captureBuffer
+		buf *bytes.Buffer
+	}{{sp.stdoutCap, &sp.Stdout}, {sp.stderrCap, &sp.Stderr}, {sp.combinedCap, &sp.Combined}} {
+		c.buf.Reset()
+		c.buf.WriteString(c.cap.String())
+
+		name, err := c.cap.close()
+		if name != "" {
+			sp.spillFiles = append(sp.spillFiles, name)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
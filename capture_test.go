@@ -0,0 +1,59 @@
+package p
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubprocess_CaptureLimits(t *testing.T) {
+	var full strings.Builder
+	for i := 1; i <= 1000; i++ {
+		full.WriteString(Format("%d\n", i))
+	}
+	out := full.String()
+
+	sp := newJob(t, "seq", "1000")
+	sp.Capture = true
+	sp.CaptureConfig = CaptureConfig{Limit: 20}
+	require.NoError(t, sp.Run())
+	require.Equal(t, out[len(out)-20:], sp.Stdout.String())
+	require.Equal(t, out[len(out)-20:], sp.Combined.String())
+
+	sp.CaptureConfig.Mode = CaptureHead
+	require.NoError(t, sp.Run())
+	require.Equal(t, out[:20], sp.Stdout.String())
+
+	sp.CaptureConfig.Mode = CaptureHeadTail
+	require.NoError(t, sp.Run())
+	require.Equal(t, out[:10]+Format("\n... [%d bytes elided] ...\n", len(out)-20)+out[len(out)-10:], sp.Stdout.String())
+
+	sp.SetArgs("3")
+	require.NoError(t, sp.Run())
+	require.Equal(t, "1\n2\n3\n", sp.Stdout.String())
+	require.Empty(t, sp.SpillFiles())
+}
+
+func TestSubprocess_CaptureSpill(t *testing.T) {
+	sp := newJob(t, "sh", "-c", "seq 1000; echo short >&2")
+	sp.Capture = true
+	sp.CaptureConfig = CaptureConfig{Limit: 100, Spill: true, SpillDir: t.TempDir()}
+	require.NoError(t, sp.Run())
+	require.Equal(t, "short\n", sp.Stderr.String())
+
+	files := sp.SpillFiles()
+	require.Len(t, files, 1)
+	require.Contains(t, files[0], "subprocess-stdout-")
+	b, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(b), "1\n2\n3\n"))
+	require.True(t, strings.HasSuffix(string(b), "\n999\n1000\n"))
+	require.Len(t, sp.Stdout.String(), 100)
+
+	require.NoError(t, sp.Cleanup())
+	require.Empty(t, sp.SpillFiles())
+	_, err = os.Stat(files[0])
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
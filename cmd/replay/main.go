@@ -0,0 +1,190 @@
+// Command replay re-injects archived or dead-lettered rows into a BigQuery table.
+//
+//	replay -project my-proj -dataset events -table clicks -format messages -checkpoint clicks.ckpt dead-letters.jsonl.gz
+//
+// Rows are read as JSON (or CSV converted to JSON), converted to the table's
+// proto schema and appended through a committed stream. Pass -dry-run to print
+// the rows instead of appending them.
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/s-hammon/p"
+	"github.com/s-hammon/p/stream"
+)
+
+func main() {
+	var (
+		project    = flag.String("project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+		dataset    = flag.String("dataset", "", "destination dataset")
+		table      = flag.String("table", "", "destination table")
+		format     = flag.String("format", "jsonl", "input format: jsonl, messages or csv")
+		checkpoint = flag.String("checkpoint", "", "checkpoint file used to resume an interrupted replay")
+		rate       = flag.Int("rate", 0, "maximum rows per second (0 = unlimited)")
+		every      = flag.Duration("progress", 5*time.Second, "progress reporting interval")
+		skip       = flag.Bool("skip-invalid", false, "skip rows that cannot be decoded or converted")
+		dryRun     = flag.Bool("dry-run", false, "print rows to stdout instead of appending them")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] FILE\n\nFILE may be gzipped (.gz) or - for stdin.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rf, err := stream.ParseReplayFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	in, err := openInput(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+
+	cfg := stream.ReplayConfig{
+		Format:           rf,
+		SkipInvalid:      *skip,
+		RowsPerSecond:    *rate,
+		Checkpoint:       *checkpoint,
+		ProgressInterval: *every,
+		Progress: func(s stream.ReplayStats) {
+			log.Println(s)
+		},
+	}
+
+	var dst stream.Stream
+	var shutdown func() error
+	if *dryRun {
+		dst = stdoutStream{}
+		shutdown = func() error { return nil }
+	} else {
+		if *project == "" || *dataset == "" || *table == "" {
+			log.Fatal("-project, -dataset and -table are required")
+		}
+
+		bq, md, err := newBigQueryStream(ctx, *project, *dataset, *table)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dst, shutdown = bq, bq.Shutdown
+		cfg.Serialize = jsonToProto(md)
+	}
+
+	_, err = stream.Replay(ctx, dst, in, cfg)
+	if serr := shutdown(); serr != nil {
+		log.Printf("shutdown: %v\n", serr)
+		if err == nil {
+			err = serr
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsFileType(name, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("gzip.NewReader: %w", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+func newBigQueryStream(ctx context.Context, project, dataset, table string) (*stream.BigQueryStream, protoreflect.MessageDescriptor, error) {
+	client, err := bigquery.NewClient(ctx, project)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bigquery.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	meta, err := client.Dataset(dataset).Table(table).Metadata(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("table metadata: %w", err)
+	}
+
+	schema, err := adapt.BQSchemaToStorageTableSchema(meta.Schema)
+	if err != nil {
+		return nil, nil, fmt.Errorf("adapt.BQSchemaToStorageTableSchema: %w", err)
+	}
+	desc, err := adapt.StorageSchemaToProto2Descriptor(schema, "root")
+	if err != nil {
+		return nil, nil, fmt.Errorf("adapt.StorageSchemaToProto2Descriptor: %w", err)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected descriptor type %T", desc)
+	}
+	dp, err := adapt.NormalizeDescriptor(md)
+	if err != nil {
+		return nil, nil, fmt.Errorf("adapt.NormalizeDescriptor: %w", err)
+	}
+
+	dest := managedwriter.TableParentFromParts(project, dataset, table)
+	s, err := stream.NewBigQueryStream(ctx, project, stream.BigQueryStreamConfig{}, stream.CommittedStreamOpts(dest, dp)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s, md, nil
+}
+
+func jsonToProto(md protoreflect.MessageDescriptor) stream.RowSerializer {
+	opts := protojson.UnmarshalOptions{DiscardUnknown: true}
+	return func(raw []byte, _ map[string]string) ([]byte, error) {
+		msg := dynamicpb.NewMessage(md)
+		if err := opts.Unmarshal(raw, msg); err != nil {
+			return nil, fmt.Errorf("protojson.Unmarshal: %w", err)
+		}
+		return proto.Marshal(msg)
+	}
+}
+
+type stdoutStream struct{}
+
+func (stdoutStream) Append(_ context.Context, row []byte) error {
+	_, err := os.Stdout.Write(append(row, '\n'))
+	return err
+}
@@ -0,0 +1,155 @@
+package p
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type LogFormat int32
+
+const (
+	// FormatAuto renders for the console when stdout is a terminal and JSON otherwise.
+	FormatAuto LogFormat = iota
+	FormatJSON
+	// FormatConsole is colored only on a terminal, unless NO_COLOR is set.
+	FormatConsole
+)
+
+// SetFormat sets how l and every logger sharing its root render entries.
+func (l *GcpLogger) SetFormat(f LogFormat) {
+	l.sink().format.Store(int32(f))
+}
+
+// console reports whether entries of severity s are rendered for the console.
+func (l *GcpLogger) console(s Severity) bool {
+	switch LogFormat(l.format.Load()) {
+	case FormatJSON:
+		return false
+	case FormatConsole:
+		return true
+	}
+
+	return l.isTTY(s)
+}
+
+// isTTY reports whether entries of severity s are written to a terminal.
+// Stdout and stderr are checked separately, as one may be redirected.
+func (l *GcpLogger) isTTY(s Severity) bool {
+	i := If(s >= Error, 1, 0)
+	l.ttyOnce[i].Do(func() {
+		l.tty[i] = isTerminal(l.writer(s))
+	})
+	return l.tty[i]
+}
+
+func isTerminal(w any) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// see https://no-color.org
+func noColor() bool {
+	return os.Getenv("NO_COLOR") != ""
+}
+
+var severityColors = [...]int{
+	Debug:     90,
+	Info:      36,
+	Notice:    34,
+	Warning:   33,
+	Error:     31,
+	Critical:  35,
+	Alert:     91,
+	Emergency: 41,
+}
+
+const (
+	consoleSeverityWidth = len("EMERGENCY")
+	consoleMessageWidth  = 40
+)
+
+// renderConsole formats an entry as a single human-readable line:
+// time, severity, message, then labels and fields as sorted key=value pairs,
+// followed by the trace ID and caller.
+func renderConsole(e Entry, color bool) string {
+	paint := func(c int, s string) string {
+		if !color {
+			return s
+		}
+		return Colorize(c, s)
+	}
+
+	var b strings.Builder
+	b.WriteString(paint(90, e.Time.Format("15:04:05.000")))
+	b.WriteByte(' ')
+
+	name, ok := e.Severity.name()
+	sevColor := 0
+	if ok {
+		sevColor = severityColors[e.Severity]
+	}
+	b.WriteString(paint(sevColor, name))
+	b.WriteString(strings.Repeat(" ", max(consoleSeverityWidth-len(name), 0)+1))
+
+	var pairs []string
+	for _, k := range sortedKeys(e.Labels) {
+		pairs = append(pairs, paint(90, k+"=")+consoleValue(e.Labels[k]))
+	}
+	for _, k := range sortedKeys(e.Fields) {
+		if IsIn(k, reservedEntryKeys...) {
+			continue
+		}
+		pairs = append(pairs, paint(90, k+"=")+consoleValue(e.Fields[k]))
+	}
+	if len(e.Trace) > 0 {
+		var name string
+		if json.Unmarshal(e.Trace, &name) == nil && name != "" {
+			// "projects/<projectID>/traces/<traceID>"
+			pairs = append(pairs, paint(90, "trace=")+path.Base(name))
+		}
+	}
+	if e.SourceLocation != nil {
+		loc := filepath.Base(e.SourceLocation.File) + ":" + strconv.Itoa(e.SourceLocation.Line)
+		pairs = append(pairs, paint(90, "caller="+loc))
+	}
+
+	b.WriteString(e.Message)
+	if len(pairs) > 0 {
+		b.WriteString(strings.Repeat(" ", max(consoleMessageWidth-len(e.Message), 0)+1))
+		b.WriteString(strings.Join(pairs, " "))
+	}
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+func consoleValue(v any) string {
+	switch t := fieldValue(v).(type) {
+	case string:
+		if t == "" || strings.ContainsAny(t, " \t\n\"=") {
+			return strconv.Quote(t)
+		}
+		return t
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return Format("%v", t)
+		}
+		return string(b)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := Keys(m)
+	slices.Sort(keys)
+	return keys
+}
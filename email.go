@@ -0,0 +1,384 @@
+package p
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+type TLSMode int
+
+const (
+	// TLSAuto uses TLSImplicit on port 465 and TLSStartTLS otherwise.
+	TLSAuto TLSMode = iota
+	// TLSImplicit connects over TLS from the start (SMTPS).
+	TLSImplicit
+	// TLSStartTLS connects in plain text and upgrades with STARTTLS,
+	// failing if the server doesn't support it.
+	TLSStartTLS
+	// TLSNone never encrypts. Only use it against local test servers.
+	TLSNone
+)
+
+func (m *TLSMode) UnmarshalText(b []byte) error {
+	switch strings.ToLower(string(b)) {
+	case "", "auto":
+		*m = TLSAuto
+	case "implicit", "tls", "smtps":
+		*m = TLSImplicit
+	case "starttls":
+		*m = TLSStartTLS
+	case "none":
+		*m = TLSNone
+	default:
+		return fmt.Errorf("unknown TLS mode %q", b)
+	}
+	return nil
+}
+
+type AuthMechanism int
+
+const (
+	// AuthAuto uses AuthPlain if a username is configured and AuthNone otherwise.
+	AuthAuto AuthMechanism = iota
+	AuthPlain
+	AuthLogin
+	AuthCRAMMD5
+	AuthNone
+)
+
+func (a *AuthMechanism) UnmarshalText(b []byte) error {
+	switch strings.ToLower(string(b)) {
+	case "", "auto":
+		*a = AuthAuto
+	case "plain":
+		*a = AuthPlain
+	case "login":
+		*a = AuthLogin
+	case "cram-md5", "crammd5":
+		*a = AuthCRAMMD5
+	case "none":
+		*a = AuthNone
+	default:
+		return fmt.Errorf("unknown auth mechanism %q", b)
+	}
+	return nil
+}
+
+type EmailConfig struct {
+	Host string
+	// Port defaults to 465 with TLSImplicit, 25 with TLSNone and 587 otherwise.
+	Port     int
+	Username string
+	Password string
+	// From is the default sender, Username if empty.
+	From string
+
+	TLS TLSMode
+	// RootCAs verifies the server certificate, the system pool if nil.
+	RootCAs *x509.CertPool
+	// CAFile is a PEM file of certificates added to RootCAs.
+	CAFile string
+	// ServerName is the name verified against the certificate, Host if empty.
+	ServerName string
+
+	Auth AuthMechanism
+	// Timeout bounds the whole exchange with the server, 30s by default.
+	Timeout time.Duration
+	// LocalName is sent in EHLO, "localhost" by default.
+	LocalName string
+}
+
+func (c EmailConfig) withDefaults() EmailConfig {
+	if c.TLS == TLSAuto {
+		c.TLS = If(c.Port == 465 || c.Port == 0, TLSImplicit, TLSStartTLS)
+	}
+	if c.Port == 0 {
+		switch c.TLS {
+		case TLSImplicit:
+			c.Port = 465
+		case TLSNone:
+			c.Port = 25
+		default:
+			c.Port = 587
+		}
+	}
+	if c.Auth == AuthAuto {
+		c.Auth = If(c.Username != "", AuthPlain, AuthNone)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+	c.From = Coalesce(c.From, c.Username)
+	c.ServerName = Coalesce(c.ServerName, c.Host)
+	c.LocalName = Coalesce(c.LocalName, "localhost")
+
+	return c
+}
+
+// The settings SendEmail used before EmailConfig. EmailConfigFromEnv falls
+// back on them where the SMTP_* variables aren't set.
+var (
+	// Deprecated: set EmailNotifier.To, or NOTIFICATION_EMAIL.
+	NotifEmail = os.Getenv("NOTIFICATION_EMAIL")
+	// Deprecated: use EmailConfig.Host, or SMTP_HOST.
+	SMTPServer = "smtp.gmail.com"
+	// Deprecated: use EmailConfig.Port, or SMTP_PORT.
+	SMTPPort = 465
+	// Deprecated: use EmailConfig.Username, or SMTP_USER.
+	SMTPUser = os.Getenv("SMTP_USER")
+	// Deprecated: use EmailConfig.Password, or SMTP_PASS.
+	SMTPPass = os.Getenv("SMTP_PASS")
+)
+
+// EmailConfigFromEnv reads an EmailConfig from SMTP_HOST (smtp.gmail.com by
+// default), SMTP_PORT, SMTP_USER, SMTP_PASS, SMTP_FROM, SMTP_TLS
+// (implicit, starttls or none), SMTP_AUTH (plain, login, cram-md5 or none),
+// SMTP_CA_FILE and SMTP_TIMEOUT (e.g. "10s").
+func EmailConfigFromEnv() (EmailConfig, error) {
+	cfg := EmailConfig{
+		Host:     Coalesce(os.Getenv("SMTP_HOST"), SMTPServer, "smtp.gmail.com"),
+		Username: Coalesce(os.Getenv("SMTP_USER"), SMTPUser),
+		Password: Coalesce(os.Getenv("SMTP_PASS"), SMTPPass),
+		From:     os.Getenv("SMTP_FROM"),
+		CAFile:   os.Getenv("SMTP_CA_FILE"),
+	}
+
+	var err error
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		if cfg.Port, err = strconv.Atoi(v); err != nil {
+			return cfg, fmt.Errorf("SMTP_PORT: %w", err)
+		}
+	} else if SMTPPort != 465 {
+		// 465 is left to withDefaults, which picks it unless SMTP_TLS says otherwise
+		cfg.Port = SMTPPort
+	}
+	if err := cfg.TLS.UnmarshalText([]byte(os.Getenv("SMTP_TLS"))); err != nil {
+		return cfg, fmt.Errorf("SMTP_TLS: %w", err)
+	}
+	if err := cfg.Auth.UnmarshalText([]byte(os.Getenv("SMTP_AUTH"))); err != nil {
+		return cfg, fmt.Errorf("SMTP_AUTH: %w", err)
+	}
+	if v := os.Getenv("SMTP_TIMEOUT"); v != "" {
+		if cfg.Timeout, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("SMTP_TIMEOUT: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// EmailClient sends mail over SMTP, opening a connection per Send.
+type EmailClient struct {
+	cfg       EmailConfig
+	tlsConfig *tls.Config
+}
+
+func NewEmailClient(cfg EmailConfig) (*EmailClient, error) {
+	cfg = cfg.withDefaults()
+	if cfg.Host == "" {
+		return nil, errors.New("email: no host")
+	}
+
+	pool := cfg.RootCAs
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("email: %w", err)
+		}
+		if pool == nil {
+			if pool, err = x509.SystemCertPool(); err != nil {
+				pool = x509.NewCertPool()
+			}
+		} else {
+			pool = pool.Clone()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("email: no certificates in %s", cfg.CAFile)
+		}
+	}
+
+	return &EmailClient{
+		cfg: cfg,
+		tlsConfig: &tls.Config{
+			ServerName: cfg.ServerName,
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		},
+	}, nil
+}
+
+func (c *EmailClient) Config() EmailConfig {
+	return c.cfg
+}
+
+// Send delivers msgs over a single connection. Messages without a From header
+// are sent from the configured sender.
+func (c *EmailClient) Send(ctx context.Context, msgs ...*gomail.Message) error {
+	for _, m := range msgs {
+		if len(m.GetHeader("From")) == 0 {
+			if c.cfg.From == "" {
+				return errors.New("email: no sender")
+			}
+			m.SetHeader("From", c.cfg.From)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	sc, err := c.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	defer sc.Close()
+
+	if err := gomail.Send(smtpSender{sc}, msgs...); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	return sc.Quit()
+}
+
+// SendHTML sends a single HTML message.
+func (c *EmailClient) SendHTML(ctx context.Context, from string, to []string, subject, content string) error {
+	m := gomail.NewMessage()
+	if from != "" {
+		m.SetHeader("From", from)
+	}
+	m.SetHeader("To", to...)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/html", content)
+
+	return c.Send(ctx, m)
+}
+
+// dial connects, upgrades to TLS and authenticates. The connection is closed
+// once ctx is done.
+func (c *EmailClient) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(c.cfg.Host, strconv.Itoa(c.cfg.Port))
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.cfg.TLS == TLSImplicit {
+		conn, err = (&tls.Dialer{Config: c.tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	context.AfterFunc(ctx, func() { conn.Close() })
+
+	sc, err := smtp.NewClient(conn, c.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.hello(sc); err != nil {
+		sc.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+func (c *EmailClient) hello(sc *smtp.Client) error {
+	if err := sc.Hello(c.cfg.LocalName); err != nil {
+		return err
+	}
+
+	if c.cfg.TLS == TLSStartTLS {
+		if ok, _ := sc.Extension("STARTTLS"); !ok {
+			return errors.New("server doesn't support STARTTLS")
+		}
+		if err := sc.StartTLS(c.tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	var auth smtp.Auth
+	switch c.cfg.Auth {
+	case AuthNone:
+		return nil
+	case AuthPlain:
+		auth = smtp.PlainAuth("", c.cfg.Username, c.cfg.Password, c.cfg.Host)
+	case AuthLogin:
+		auth = &loginAuth{username: c.cfg.Username, password: c.cfg.Password, host: c.cfg.Host}
+	case AuthCRAMMD5:
+		auth = smtp.CRAMMD5Auth(c.cfg.Username, c.cfg.Password)
+	}
+	if ok, _ := sc.Extension("AUTH"); !ok {
+		return errors.New("server doesn't support AUTH")
+	}
+	return sc.Auth(auth)
+}
+
+// smtpSender adapts an smtp.Client to gomail.Sender.
+type smtpSender struct {
+	sc *smtp.Client
+}
+
+func (s smtpSender) Send(from string, to []string, msg io.WriterTo) error {
+	if err := s.sc.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := s.sc.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := s.sc.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp lacks.
+// Like smtp.PlainAuth, it refuses to send credentials unencrypted except to localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !IsIn(server.Name, "localhost", "127.0.0.1", "::1") {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
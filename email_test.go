@@ -0,0 +1,356 @@
+package p
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/gomail.v2"
+)
+
+type smtpMessage struct {
+	Auth string
+	From string
+	To   []string
+	Data string
+}
+
+// fakeSMTP is a minimal SMTP server accepting user "user" with password "secret".
+type fakeSMTP struct {
+	ln       net.Listener
+	tls      *tls.Config
+	implicit bool
+
+	mu   sync.Mutex
+	msgs []smtpMessage
+}
+
+// newFakeSMTP starts a server with a self-signed certificate for 127.0.0.1,
+// returned PEM encoded. It offers STARTTLS unless implicit.
+func newFakeSMTP(t *testing.T, implicit bool) (*fakeSMTP, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	s := &fakeSMTP{
+		implicit: implicit,
+		tls: &tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}}},
+	}
+	if implicit {
+		s.ln, err = tls.Listen("tcp", "127.0.0.1:0", s.tls)
+	} else {
+		s.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { s.ln.Close() })
+
+	go func() {
+		for {
+			conn, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func (s *fakeSMTP) config() EmailConfig {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return EmailConfig{Host: host, Port: n, Username: "user", Password: "secret", Timeout: 5 * time.Second}
+}
+
+func (s *fakeSMTP) messages() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMessage(nil), s.msgs...)
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	isTLS := s.implicit
+	var msg smtpMessage
+
+	reply := func(line string) bool {
+		return tp.PrintfLine("%s", line) == nil
+	}
+	readB64 := func() string {
+		line, _ := tp.ReadLine()
+		b, _ := base64.StdEncoding.DecodeString(line)
+		return string(b)
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			reply("250-fake")
+			if !isTLS {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN LOGIN CRAM-MD5")
+		case "STARTTLS":
+			reply("220 go ahead")
+			tc := tls.Server(conn, s.tls)
+			if tc.Handshake() != nil {
+				return
+			}
+			conn, tp, isTLS = tc, textproto.NewConn(tc), true
+		case "AUTH":
+			mech, resp, _ := strings.Cut(arg, " ")
+			var user, pass string
+			switch mech {
+			case "PLAIN":
+				b, _ := base64.StdEncoding.DecodeString(resp)
+				parts := strings.Split(string(b), "\x00")
+				user, pass = parts[1], parts[2]
+			case "LOGIN":
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				user = readB64()
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				pass = readB64()
+			case "CRAM-MD5":
+				challenge := "<1@fake>"
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte(challenge)))
+				u, digest, _ := strings.Cut(readB64(), " ")
+				d := hmac.New(md5.New, []byte("secret"))
+				d.Write([]byte(challenge))
+				user, pass = u, If(digest == hex.EncodeToString(d.Sum(nil)), "secret", "")
+			}
+			if user != "user" || pass != "secret" {
+				reply("535 bad credentials")
+				continue
+			}
+			msg.Auth = mech
+			reply("235 ok")
+		case "MAIL":
+			msg.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			msg.To = append(msg.To, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			b, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = string(b)
+			s.mu.Lock()
+			s.msgs = append(s.msgs, msg)
+			s.mu.Unlock()
+			msg = smtpMessage{Auth: msg.Auth}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestEmailClient_StartTLS(t *testing.T) {
+	srv, cert := newFakeSMTP(t, false)
+
+	cfg := srv.config()
+	cfg.TLS = TLSStartTLS
+	cfg.From = "jobs@example.com"
+	cfg.RootCAs = x509.NewCertPool()
+	cfg.RootCAs.AppendCertsFromPEM(cert)
+	c, err := NewEmailClient(cfg)
+	require.NoError(t, err)
+
+	m := gomail.NewMessage()
+	m.SetHeader("To", "a@example.com")
+	m.SetHeader("Cc", "b@example.com")
+	m.SetHeader("Bcc", "c@example.com")
+	m.SetHeader("Subject", "hi")
+	m.SetBody("text/plain", "hello")
+	require.NoError(t, c.Send(context.Background(), m))
+
+	got := srv.messages()
+	require.Len(t, got, 1)
+	require.Equal(t, "PLAIN", got[0].Auth)
+	require.Equal(t, "jobs@example.com", got[0].From)
+	require.ElementsMatch(t, []string{"a@example.com", "b@example.com", "c@example.com"}, got[0].To)
+	require.Contains(t, got[0].Data, "Subject: hi")
+	require.NotContains(t, got[0].Data, "Bcc")
+
+	// the certificate must be verified
+	cfg.RootCAs = nil
+	c, err = NewEmailClient(cfg)
+	require.NoError(t, err)
+	err = c.SendHTML(context.Background(), "", []string{"a@example.com"}, "hi", "<p>hello</p>")
+	require.ErrorContains(t, err, "certificate")
+	require.Len(t, srv.messages(), 1)
+}
+
+func TestEmailClient_Implicit(t *testing.T) {
+	srv, cert := newFakeSMTP(t, true)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, cert, 0o600))
+
+	cfg := srv.config()
+	cfg.TLS = TLSImplicit
+	cfg.CAFile = caFile
+	cfg.Auth = AuthLogin
+	cfg.From = "bot@example.com"
+	c, err := NewEmailClient(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, c.SendHTML(context.Background(), "", []string{"a@example.com"}, "hi", "<p>hello</p>"))
+	got := srv.messages()
+	require.Len(t, got, 1)
+	require.Equal(t, "LOGIN", got[0].Auth)
+	require.Equal(t, "bot@example.com", got[0].From)
+}
+
+func TestEmailClient_CRAMMD5(t *testing.T) {
+	srv, _ := newFakeSMTP(t, false)
+
+	cfg := srv.config()
+	cfg.TLS = TLSNone
+	cfg.Auth = AuthCRAMMD5
+	c, err := NewEmailClient(cfg)
+	require.NoError(t, err)
+	require.NoError(t, c.SendHTML(context.Background(), "me@example.com", []string{"a@example.com"}, "hi", "hello"))
+	require.Equal(t, "CRAM-MD5", srv.messages()[0].Auth)
+
+	cfg.Password = "wrong"
+	c, err = NewEmailClient(cfg)
+	require.NoError(t, err)
+	require.ErrorContains(t, c.SendHTML(context.Background(), "me@example.com", []string{"a@example.com"}, "hi", "hello"), "535")
+}
+
+func TestEmailClient_Timeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// never greet
+			go func() { _, _ = bufio.NewReader(conn).ReadString('\n') }()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	n, _ := strconv.Atoi(port)
+	c, err := NewEmailClient(EmailConfig{Host: host, Port: n, TLS: TLSNone, Timeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.Error(t, c.SendHTML(context.Background(), "me@example.com", []string{"a@example.com"}, "hi", "hello"))
+	require.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestEmailConfigFromEnv(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("SMTP_PORT", "")
+	t.Setenv("SMTP_USER", "bot@example.com")
+	t.Setenv("SMTP_TLS", "")
+	t.Setenv("SMTP_AUTH", "")
+	t.Setenv("SMTP_TIMEOUT", "")
+
+	cfg, err := EmailConfigFromEnv()
+	require.NoError(t, err)
+	cfg = cfg.withDefaults()
+	require.Equal(t, "smtp.gmail.com", cfg.Host)
+	require.Equal(t, 465, cfg.Port)
+	require.Equal(t, TLSImplicit, cfg.TLS)
+	require.Equal(t, AuthPlain, cfg.Auth)
+	require.Equal(t, "bot@example.com", cfg.From)
+
+	t.Setenv("SMTP_PORT", "587")
+	t.Setenv("SMTP_AUTH", "cram-md5")
+	t.Setenv("SMTP_TIMEOUT", "5s")
+	cfg, err = EmailConfigFromEnv()
+	require.NoError(t, err)
+	cfg = cfg.withDefaults()
+	require.Equal(t, TLSStartTLS, cfg.TLS)
+	require.Equal(t, AuthCRAMMD5, cfg.Auth)
+	require.Equal(t, 5*time.Second, cfg.Timeout)
+
+	t.Setenv("SMTP_TLS", "maybe")
+	_, err = EmailConfigFromEnv()
+	require.ErrorContains(t, err, "SMTP_TLS")
+}
+
+func TestEmailConfigFromEnv_Deprecated(t *testing.T) {
+	for _, k := range []string{"SMTP_HOST", "SMTP_PORT", "SMTP_USER", "SMTP_PASS", "SMTP_TLS", "SMTP_AUTH", "SMTP_TIMEOUT"} {
+		t.Setenv(k, "")
+	}
+	server, port, user, pass := SMTPServer, SMTPPort, SMTPUser, SMTPPass
+	defer func() { SMTPServer, SMTPPort, SMTPUser, SMTPPass = server, port, user, pass }()
+	SMTPServer, SMTPPort, SMTPUser, SMTPPass = "mail.example.com", 2525, "old", "pw"
+
+	cfg, err := EmailConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, "mail.example.com", cfg.Host)
+	require.Equal(t, 2525, cfg.Port)
+	require.Equal(t, "old", cfg.Username)
+	require.Equal(t, "pw", cfg.Password)
+}
+
+func TestEmailNotifier_NoRecipients(t *testing.T) {
+	t.Setenv("NOTIFICATION_EMAIL", "")
+	defer func(v string) { NotifEmail = v }(NotifEmail)
+	NotifEmail = ""
+
+	c, err := NewEmailClient(EmailConfig{Host: "127.0.0.1", Port: 1})
+	require.NoError(t, err)
+	err = (&EmailNotifier{Client: c}).Notify(context.Background(), Notification{Subject: "x"})
+	require.ErrorContains(t, err, "no recipients")
+}
@@ -1,7 +1,47 @@
 package p
 
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
 type Graph[T comparable] struct {
 	nodes map[T][]T
+	order []T
+}
+
+func NewGraph[T comparable]() *Graph[T] {
+	return &Graph[T]{nodes: make(map[T][]T)}
+}
+
+func (g *Graph[T]) AddNode(n T) {
+	if g.nodes == nil {
+		g.nodes = make(map[T][]T)
+	}
+	if _, ok := g.nodes[n]; !ok {
+		g.nodes[n] = nil
+		g.order = append(g.order, n)
+	}
+}
+
+// AddEdge adds an edge from -> to, adding either node if needed.
+func (g *Graph[T]) AddEdge(from, to T) {
+	g.AddNode(from)
+	g.AddNode(to)
+	if !slices.Contains(g.nodes[from], to) {
+		g.nodes[from] = append(g.nodes[from], to)
+	}
+}
+
+// Nodes returns the nodes in the order they were added.
+func (g *Graph[T]) Nodes() []T {
+	return slices.Clone(g.order)
+}
+
+// Neighbors returns the nodes n has edges to.
+func (g *Graph[T]) Neighbors(n T) []T {
+	return slices.Clone(g.nodes[n])
 }
 
 func BFS[T comparable](g *Graph[T], start T) []T {
@@ -25,3 +65,82 @@ func BFS[T comparable](g *Graph[T], start T) []T {
 
 	return res
 }
+
+// CycleError is returned by TopoSort for a graph with a cycle.
+// Cycle starts and ends with the same node.
+type CycleError[T comparable] struct {
+	Cycle []T
+}
+
+func (e *CycleError[T]) Error() string {
+	parts := make([]string, len(e.Cycle))
+	for i, n := range e.Cycle {
+		parts[i] = fmt.Sprint(n)
+	}
+	return "cycle: " + strings.Join(parts, " -> ")
+}
+
+// TopoSort orders the nodes of g so every node comes before the nodes it has
+// edges to. Ties are broken by the order nodes were added, so the result is stable.
+func TopoSort[T comparable](g *Graph[T]) ([]T, error) {
+	indegree := make(map[T]int, len(g.order))
+	for _, n := range g.order {
+		for _, m := range g.nodes[n] {
+			indegree[m]++
+		}
+	}
+
+	var queue, res []T
+	for _, n := range g.order {
+		if indegree[n] == 0 {
+			queue = append(queue, n)
+		}
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		res = append(res, n)
+		for _, m := range g.nodes[n] {
+			if indegree[m]--; indegree[m] == 0 {
+				queue = append(queue, m)
+			}
+		}
+	}
+
+	if len(res) < len(g.order) {
+		return nil, &CycleError[T]{Cycle: findCycle(g, indegree)}
+	}
+	return res, nil
+}
+
+// findCycle walks the nodes TopoSort couldn't order (those with a remaining
+// indegree) until one repeats. Each of them has an incoming edge from another,
+// so walking backwards always finds a cycle.
+func findCycle[T comparable](g *Graph[T], indegree map[T]int) []T {
+	preds := make(map[T]T)
+	var start T
+	for _, n := range g.order {
+		if indegree[n] == 0 {
+			continue
+		}
+		start = n
+		for _, m := range g.nodes[n] {
+			if indegree[m] > 0 {
+				preds[m] = n
+			}
+		}
+	}
+
+	seen := make(map[T]int)
+	var path []T
+	for n := start; ; n = preds[n] {
+		if i, ok := seen[n]; ok {
+			cycle := slices.Clone(path[i:])
+			slices.Reverse(cycle)
+			return append(cycle, cycle[0])
+		}
+		seen[n] = len(path)
+		path = append(path, n)
+	}
+}
@@ -0,0 +1,189 @@
+package p
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// SeverityVar is a Severity that can be changed at runtime.
+// It is safe for concurrent use; the zero value lets every entry through.
+type SeverityVar struct {
+	v atomic.Int32
+}
+
+func (v *SeverityVar) Level() Severity {
+	return Severity(v.v.Load())
+}
+
+func (v *SeverityVar) Set(s Severity) {
+	v.v.Store(int32(s))
+}
+
+func (l *GcpLogger) levelVar() *SeverityVar {
+	if l.level != nil {
+		return l.level
+	}
+	return &l.sink().lvl
+}
+
+// Enabled reports whether entries of severity s are written.
+func (l *GcpLogger) Enabled(s Severity) bool {
+	return s >= l.levelVar().Level()
+}
+
+func (l *GcpLogger) Level() Severity {
+	return l.levelVar().Level()
+}
+
+// SetLevel sets the minimum severity written by l and every logger derived
+// from it that hasn't been given its own level with Named.
+func (l *GcpLogger) SetLevel(s Severity) {
+	l.levelVar().Set(s)
+}
+
+// Named returns a sub-logger for a component, labelled "component": name.
+// Its level starts out as l's and is then controlled separately, through
+// SetLevel, SetComponentLevel, LevelFromEnv or LevelHandler.
+// Nested names are joined with dots, e.g. "stream.push".
+func (l *GcpLogger) Named(name string) *GcpLogger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+
+	c := l.WithLabels(map[string]string{"component": name})
+	c.name = name
+	c.level = l.sink().component(name, l.Level())
+	return c
+}
+
+// component returns the level of the named component, registering it at s if it is new.
+func (l *GcpLogger) component(name string, s Severity) *SeverityVar {
+	l.regMu.Lock()
+	defer l.regMu.Unlock()
+
+	if v, ok := l.components[name]; ok {
+		return v
+	}
+	if l.components == nil {
+		l.components = make(map[string]*SeverityVar)
+	}
+	v := &SeverityVar{}
+	v.Set(s)
+	l.components[name] = v
+	return v
+}
+
+// SetComponentLevel sets the level of the named component, whether or not
+// Named has been called for it yet.
+func (l *GcpLogger) SetComponentLevel(name string, s Severity) {
+	l.sink().component(name, s).Set(s)
+}
+
+// Components returns the level of every component registered so far.
+func (l *GcpLogger) Components() map[string]Severity {
+	root := l.sink()
+	root.regMu.Lock()
+	defer root.regMu.Unlock()
+
+	m := make(map[string]Severity, len(root.components))
+	for name, v := range root.components {
+		m[name] = v.Level()
+	}
+	return m
+}
+
+// LevelFromEnv sets levels from the environment variable key (LOG_LEVEL if empty),
+// formatted as a default severity and/or component overrides, e.g. "INFO,stream.push=DEBUG".
+// An unset variable leaves the levels unchanged.
+func (l *GcpLogger) LevelFromEnv(key string) error {
+	if key == "" {
+		key = "LOG_LEVEL"
+	}
+	spec := os.Getenv(key)
+	if spec == "" {
+		return nil
+	}
+	if err := l.setLevels(spec); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+func (l *GcpLogger) setLevels(spec string) error {
+	levels := make(map[string]Severity)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, lvl, found := strings.Cut(part, "=")
+		if !found {
+			name, lvl = "", name
+		}
+		s, err := ParseSeverity(lvl)
+		if err != nil {
+			return err
+		}
+		levels[strings.TrimSpace(name)] = s
+	}
+
+	// only apply once every part has parsed
+	for name, s := range levels {
+		if name == "" {
+			l.SetLevel(s)
+		} else {
+			l.SetComponentLevel(name, s)
+		}
+	}
+	return nil
+}
+
+type levelState struct {
+	Level      Severity            `json:"level"`
+	Components map[string]Severity `json:"components,omitempty"`
+}
+
+// LevelHandler serves the levels of l's tree of loggers as JSON on GET, and
+// changes them on PUT or POST, given a "level" and optional "component"
+// parameter, e.g. PUT /loglevel?component=stream.push&level=DEBUG.
+// Mount it on an admin-only route.
+func (l *GcpLogger) LevelHandler() http.Handler {
+	root := l.sink()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			s, err := ParseSeverity(r.FormValue("level"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if name := r.FormValue("component"); name != "" {
+				root.SetComponentLevel(name, s)
+			} else {
+				root.SetLevel(s)
+			}
+		}
+
+		state := levelState{Level: root.Level(), Components: root.Components()}
+		if state.Level < Debug {
+			state.Level = Debug
+		}
+		for name, s := range state.Components {
+			if s < Debug {
+				state.Components[name] = Debug
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(state)
+	})
+}
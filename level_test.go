@@ -0,0 +1,90 @@
+package p
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGcpLogger_Level(t *testing.T) {
+	var out bytes.Buffer
+	l := NewGcpLogger(&out, &out)
+
+	l.Debug("everything by default")
+	l.SetLevel(Warning)
+	l.With("k", "v").Info("filtered")
+	l.Warning("kept")
+
+	got := decodeLines(t, &out)
+	require.Len(t, got, 2)
+	require.Equal(t, "everything by default", got[0]["message"])
+	require.Equal(t, "kept", got[1]["message"])
+}
+
+func TestGcpLogger_Named(t *testing.T) {
+	var out bytes.Buffer
+	l := NewGcpLogger(&out, &out)
+	l.SetLevel(Info)
+
+	push := l.Named("push")
+	ser := push.Named("serializer")
+	require.Equal(t, Info, ser.Level())
+
+	push.SetLevel(Debug)
+	l.SetLevel(Error)
+	push.Debug("push debug")
+	ser.Debug("serializer debug")
+	l.Warning("root warning")
+
+	got := decodeLines(t, &out)
+	require.Len(t, got, 1)
+	require.Equal(t, "push debug", got[0]["message"])
+	require.Equal(t, map[string]any{"component": "push"}, got[0]["logging.googleapis.com/labels"])
+
+	require.Equal(t, map[string]Severity{"push": Debug, "push.serializer": Info}, l.Components())
+	require.Same(t, push.level, l.Named("push").level)
+}
+
+func TestGcpLogger_LevelFromEnv(t *testing.T) {
+	l := NewGcpLogger(nil, nil)
+	t.Setenv("LOG_LEVEL", "warn, stream=DEBUG")
+	require.NoError(t, l.LevelFromEnv(""))
+	require.Equal(t, Warning, l.Level())
+	require.Equal(t, Debug, l.Named("stream").Level())
+
+	t.Setenv("LOG_LEVEL", "INFO,stream=LOUD")
+	require.Error(t, l.LevelFromEnv(""))
+	require.Equal(t, Warning, l.Level())
+}
+
+func TestGcpLogger_LevelHandler(t *testing.T) {
+	l := NewGcpLogger(nil, nil)
+	l.Named("push")
+	h := l.LevelHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/?level=error", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, Error, l.Level())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?component=push&level=NOTICE", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var state map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	require.Equal(t, "ERROR", state["level"])
+	require.Equal(t, map[string]any{"push": "NOTICE"}, state["components"])
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/?level=nope", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
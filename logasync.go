@@ -0,0 +1,278 @@
+package p
+
+import (
+	"bufio"
+	"errors"
+	"hash/fnv"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type OverflowPolicy int
+
+const (
+	// OverflowDrop discards entries while the buffer is full (see GcpLogger.Dropped).
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock makes the logging call wait for room in the buffer.
+	OverflowBlock
+)
+
+type AsyncConfig struct {
+	// BufferSize is the number of entries queued before Policy applies.
+	BufferSize int
+	// FlushInterval is how often buffered output is flushed to the writers.
+	FlushInterval time.Duration
+	Policy        OverflowPolicy
+}
+
+func (c AsyncConfig) withDefaults() AsyncConfig {
+	if c.BufferSize <= 0 {
+		c.BufferSize = 1024
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+
+	return c
+}
+
+// Async makes l and every logger sharing its root encode entries on the
+// calling goroutine but write them from a background one. Call Sync before
+// exiting so queued entries aren't lost. Calling Async again replaces the
+// previous writer after flushing it.
+func (l *GcpLogger) Async(cfg AsyncConfig) {
+	aw := newAsyncWriter(cfg.withDefaults())
+	if old := l.sink().async.Swap(aw); old != nil {
+		_ = old.close()
+	}
+}
+
+// Sync writes out every queued entry and flushes buffered output.
+func (l *GcpLogger) Sync() error {
+	if aw := l.sink().async.Load(); aw != nil {
+		return aw.sync()
+	}
+	return nil
+}
+
+// Close flushes queued entries and switches l back to synchronous writes.
+func (l *GcpLogger) Close() error {
+	if aw := l.sink().async.Swap(nil); aw != nil {
+		return aw.close()
+	}
+	return nil
+}
+
+// Dropped returns the number of entries discarded because the async buffer was full.
+func (l *GcpLogger) Dropped() int64 {
+	if aw := l.sink().async.Load(); aw != nil {
+		return aw.dropped.Load()
+	}
+	return 0
+}
+
+type asyncItem struct {
+	w    io.Writer
+	b    []byte
+	sync chan error
+}
+
+type asyncWriter struct {
+	ch      chan asyncItem
+	policy  OverflowPolicy
+	dropped atomic.Int64
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAsyncWriter(cfg AsyncConfig) *asyncWriter {
+	aw := &asyncWriter{
+		ch:     make(chan asyncItem, cfg.BufferSize),
+		policy: cfg.Policy,
+		done:   make(chan struct{}),
+	}
+	go aw.loop(cfg.FlushInterval)
+	return aw
+}
+
+// enqueue returns false if the writer is closed and the entry wasn't handled.
+func (aw *asyncWriter) enqueue(w io.Writer, b []byte) bool {
+	aw.mu.RLock()
+	defer aw.mu.RUnlock()
+	if aw.closed {
+		return false
+	}
+
+	item := asyncItem{w: w, b: b}
+	if aw.policy == OverflowBlock {
+		aw.ch <- item
+		return true
+	}
+
+	select {
+	case aw.ch <- item:
+	default:
+		aw.dropped.Add(1)
+	}
+	return true
+}
+
+func (aw *asyncWriter) sync() error {
+	aw.mu.RLock()
+	if aw.closed {
+		aw.mu.RUnlock()
+		return nil
+	}
+	reply := make(chan error, 1)
+	aw.ch <- asyncItem{sync: reply}
+	aw.mu.RUnlock()
+
+	return <-reply
+}
+
+func (aw *asyncWriter) close() error {
+	err := aw.sync()
+
+	aw.mu.Lock()
+	if !aw.closed {
+		aw.closed = true
+		close(aw.ch)
+	}
+	aw.mu.Unlock()
+
+	<-aw.done
+	return err
+}
+
+func (aw *asyncWriter) loop(interval time.Duration) {
+	defer close(aw.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	bufs := make(map[io.Writer]*bufio.Writer)
+	var errs []error
+	flush := func() error {
+		for _, bw := range bufs {
+			if err := bw.Flush(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		err := errors.Join(errs...)
+		errs = errs[:0]
+		return err
+	}
+
+	for {
+		select {
+		case <-t.C:
+			_ = flush()
+		case item, ok := <-aw.ch:
+			if !ok {
+				_ = flush()
+				return
+			}
+			if item.sync != nil {
+				item.sync <- flush()
+				continue
+			}
+
+			bw, ok := bufs[item.w]
+			if !ok {
+				bw = bufio.NewWriterSize(item.w, 32*1024)
+				bufs[item.w] = bw
+			}
+			if _, err := bw.Write(item.b); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+}
+
+type SamplingConfig struct {
+	// Tick is the period over which entries are counted, 1s by default.
+	Tick time.Duration
+	// First is the number of entries with the same severity and message logged per Tick.
+	First int
+	// Thereafter logs every Mth entry after First. Zero drops them all.
+	Thereafter int
+}
+
+// Sample limits how many identical entries l and every logger sharing its
+// root write per tick, to protect against error storms. Entries are
+// identified by severity and message (after formatting). A zero First
+// disables sampling.
+func (l *GcpLogger) Sample(cfg SamplingConfig) {
+	if cfg.First <= 0 {
+		l.sink().sampler.Store(nil)
+		return
+	}
+	if cfg.Tick <= 0 {
+		cfg.Tick = time.Second
+	}
+	l.sink().sampler.Store(&sampler{cfg: cfg})
+}
+
+// SampledOut returns the number of entries discarded by sampling.
+func (l *GcpLogger) SampledOut() int64 {
+	if sp := l.sink().sampler.Load(); sp != nil {
+		return sp.dropped.Load()
+	}
+	return 0
+}
+
+const samplerBuckets = 4096
+
+// sampler counts entries in a fixed number of hashed buckets, so memory stays
+// bounded however many distinct messages are logged (at the cost of collisions).
+type sampler struct {
+	cfg     SamplingConfig
+	counts  [samplerBuckets]sampleCounter
+	dropped atomic.Int64
+
+	now func() time.Time
+}
+
+type sampleCounter struct {
+	resetAt atomic.Int64
+	n       atomic.Int64
+}
+
+func (sp *sampler) keep(s Severity, msg string) bool {
+	h := fnv.New32a()
+	h.Write([]byte{byte(s)})
+	h.Write([]byte(msg))
+
+	now := time.Now
+	if sp.now != nil {
+		now = sp.now
+	}
+
+	n := sp.counts[h.Sum32()%samplerBuckets].inc(now(), sp.cfg.Tick)
+	first := int64(sp.cfg.First)
+	if n <= first || (sp.cfg.Thereafter > 0 && (n-first)%int64(sp.cfg.Thereafter) == 0) {
+		return true
+	}
+
+	sp.dropped.Add(1)
+	return false
+}
+
+func (c *sampleCounter) inc(t time.Time, tick time.Duration) int64 {
+	tn := t.UnixNano()
+	resetAt := c.resetAt.Load()
+	if resetAt > tn {
+		return c.n.Add(1)
+	}
+
+	c.n.Store(1)
+	if !c.resetAt.CompareAndSwap(resetAt, tn+tick.Nanoseconds()) {
+		// another goroutine reset it first
+		return c.n.Add(1)
+	}
+	return 1
+}
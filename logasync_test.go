@@ -0,0 +1,102 @@
+package p
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) snapshot() *bytes.Buffer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.NewBuffer(bytes.Clone(b.buf.Bytes()))
+}
+
+func TestGcpLogger_Async(t *testing.T) {
+	var out syncBuffer
+	l := NewGcpLogger(&out, &out)
+	l.Async(AsyncConfig{FlushInterval: time.Hour, Policy: OverflowBlock})
+
+	for i := range 100 {
+		l.Info("entry %d", i)
+	}
+	require.Empty(t, out.snapshot().String())
+
+	require.NoError(t, l.Sync())
+	got := decodeLines(t, out.snapshot())
+	require.Len(t, got, 100)
+	require.Equal(t, "entry 99", got[99]["message"])
+
+	require.NoError(t, l.Close())
+	l.Info("sync again")
+	require.Len(t, decodeLines(t, out.snapshot()), 101)
+}
+
+type blockingWriter struct {
+	release chan struct{}
+	io.Writer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.Writer.Write(p)
+}
+
+func TestGcpLogger_AsyncDrop(t *testing.T) {
+	var out syncBuffer
+	w := &blockingWriter{release: make(chan struct{}), Writer: &out}
+	l := NewGcpLogger(w, w)
+	l.Async(AsyncConfig{BufferSize: 1, FlushInterval: time.Millisecond})
+
+	for range 50 {
+		l.Info("storm")
+	}
+	require.Positive(t, l.Dropped())
+
+	close(w.release)
+	require.NoError(t, l.Close())
+	require.Less(t, len(decodeLines(t, out.snapshot())), 50)
+}
+
+func TestGcpLogger_Sample(t *testing.T) {
+	var out bytes.Buffer
+	l := NewGcpLogger(&out, &out)
+	l.Sample(SamplingConfig{First: 2, Thereafter: 3})
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.sampler.Load().now = func() time.Time { return now }
+
+	for range 10 {
+		l.Error("same")
+	}
+	l.Error("different")
+
+	// 1, 2, then 5 and 8
+	got := decodeLines(t, &out)
+	require.Len(t, got, 5)
+	require.Equal(t, "different", got[4]["message"])
+	require.Equal(t, int64(6), l.SampledOut())
+
+	out.Reset()
+	now = now.Add(time.Second)
+	l.Error("same")
+	require.Len(t, decodeLines(t, &out), 1)
+
+	l.Sample(SamplingConfig{})
+	require.Zero(t, l.SampledOut())
+}
@@ -1,11 +1,20 @@
 package p
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
+	"net"
+	"net/http"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func Colorize(color int, text string) string {
@@ -26,39 +35,396 @@ const (
 	Emergency
 )
 
+var severityNames = [...]string{
+	Debug:     "DEBUG",
+	Info:      "INFO",
+	Notice:    "NOTICE",
+	Warning:   "WARNING",
+	Error:     "ERROR",
+	Critical:  "CRITICAL",
+	Alert:     "ALERT",
+	Emergency: "EMERGENCY",
+}
+
+func (s Severity) name() (string, bool) {
+	if s < Debug || s > Emergency {
+		return "UNKNOWN", false
+	}
+	return severityNames[s], true
+}
+
+// ParseSeverity parses a severity name, case-insensitively. Besides the
+// Cloud Logging names, WARN, ERR and FATAL are accepted.
+func ParseSeverity(text string) (Severity, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	for s := Debug; s <= Emergency; s++ {
+		if severityNames[s] == text {
+			return s, nil
+		}
+	}
+	// accept the common aliases of other loggers
+	switch text {
+	case "WARN":
+		return Warning, nil
+	case "ERR":
+		return Error, nil
+	case "FATAL":
+		return Critical, nil
+	}
+	return 0, fmt.Errorf("unknown severity: %q", text)
+}
+
+func (s Severity) String() string {
+	if s == 0 {
+		return "DEFAULT"
+	}
+	if name, ok := s.name(); ok {
+		return name
+	}
+	return Format("Severity(%d)", int32(s))
+}
+
+// MarshalJSON writes the severity name. The zero Severity is "DEFAULT",
+// as in Cloud Logging.
 func (s Severity) MarshalJSON() ([]byte, error) {
-	switch s {
-	default:
+	if s == 0 {
+		return []byte(`"DEFAULT"`), nil
+	}
+	name, ok := s.name()
+	if !ok {
 		return []byte(`"UNKNOWN"`), fmt.Errorf("unknown severity: %d", s)
-	case Debug:
-		return []byte(`"DEBUG"`), nil
-	case Info:
-		return []byte(`"INFO"`), nil
-	case Notice:
-		return []byte(`"NOTICE"`), nil
-	case Warning:
-		return []byte(`"WARNING"`), nil
-	case Error:
-		return []byte(`"ERROR"`), nil
-	case Critical:
-		return []byte(`"CRITICAL"`), nil
-	case Alert:
-		return []byte(`"ALERT"`), nil
-	case Emergency:
-		return []byte(`"EMERGENCY"`), nil
 	}
+	return []byte(`"` + name + `"`), nil
+}
+
+// UnmarshalJSON accepts a severity name, or a number: either a Severity or a
+// Cloud Logging LogSeverity (DEBUG is 100, INFO 200 and so on).
+// "DEFAULT" and 0 unmarshal to the zero Severity.
+func (s *Severity) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var text string
+		if err := json.Unmarshal(b, &text); err != nil {
+			return err
+		}
+		return s.UnmarshalText([]byte(text))
+	}
+
+	var n int32
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("invalid severity: %s", b)
+	}
+	if n >= 100 && n%100 == 0 {
+		n /= 100
+	}
+	if n != 0 && (Severity(n) < Debug || Severity(n) > Emergency) {
+		return fmt.Errorf("unknown severity: %d", n)
+	}
+	*s = Severity(n)
+	return nil
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	if s == 0 {
+		return []byte("DEFAULT"), nil
+	}
+	name, ok := s.name()
+	if !ok {
+		return nil, fmt.Errorf("unknown severity: %d", s)
+	}
+	return []byte(name), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	if strings.EqualFold(strings.TrimSpace(string(text)), "DEFAULT") {
+		*s = 0
+		return nil
+	}
+	v, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// Set implements flag.Value, e.g. flag.Var(&lvl, "level", "minimum severity").
+func (s *Severity) Set(text string) error {
+	return s.UnmarshalText([]byte(text))
 }
 
 type Entry struct {
-	Message  string          `json:"message"`
-	Severity Severity        `json:"severity,omitempty"`
-	Trace    json.RawMessage `json:"logging.googleapis.com/trace,omitempty"`
+	Message        string            `json:"message"`
+	Severity       Severity          `json:"severity,omitempty"`
+	Time           time.Time         `json:"time,omitzero"`
+	Trace          json.RawMessage   `json:"logging.googleapis.com/trace,omitempty"`
+	SpanID         string            `json:"logging.googleapis.com/spanId,omitempty"`
+	TraceSampled   bool              `json:"logging.googleapis.com/trace_sampled,omitempty"`
+	InsertID       string            `json:"logging.googleapis.com/insertId,omitempty"`
+	Labels         map[string]string `json:"logging.googleapis.com/labels,omitempty"`
+	SourceLocation *SourceLocation   `json:"logging.googleapis.com/sourceLocation,omitempty"`
+	HttpRequest    *HttpRequest      `json:"httpRequest,omitempty"`
+	// Set by ReportError and ReportCritical for Cloud Error Reporting.
+	Type           string          `json:"@type,omitempty"`
+	ServiceContext *ServiceContext `json:"serviceContext,omitempty"`
+	StackTrace     string          `json:"stack_trace,omitempty"`
+	ErrorContext   *ErrorContext   `json:"context,omitempty"`
+	// Fields are merged into the top level of the jsonPayload.
+	// Keys colliding with the fields above are dropped.
+	Fields map[string]any `json:"-"`
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	b, err := marshalNoEscape(entry(e))
+	if err != nil || len(e.Fields) == 0 {
+		return b, err
+	}
+
+	buf := bytes.NewBuffer(b[:len(b)-1])
+	for _, k := range sortedKeys(e.Fields) {
+		if IsIn(k, reservedEntryKeys...) {
+			continue
+		}
+		v, err := marshalNoEscape(fieldValue(e.Fields[k]))
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", k, err)
+		}
+		key, _ := marshalNoEscape(k)
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON is the inverse of MarshalJSON: keys that aren't Entry fields are collected into Fields.
+func (e *Entry) UnmarshalJSON(b []byte) error {
+	type entry Entry
+	var ee entry
+	if err := json.Unmarshal(b, &ee); err != nil {
+		return err
+	}
+
+	var all map[string]any
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	for k, v := range all {
+		if IsIn(k, reservedEntryKeys...) {
+			continue
+		}
+		if ee.Fields == nil {
+			ee.Fields = make(map[string]any)
+		}
+		ee.Fields[k] = v
+	}
+
+	*e = Entry(ee)
+	return nil
+}
+
+var reservedEntryKeys = []string{
+	"message",
+	"severity",
+	"time",
+	"httpRequest",
+	"@type",
+	"serviceContext",
+	"stack_trace",
+	"context",
+	"logging.googleapis.com/trace",
+	"logging.googleapis.com/spanId",
+	"logging.googleapis.com/trace_sampled",
+	"logging.googleapis.com/insertId",
+	"logging.googleapis.com/labels",
+	"logging.googleapis.com/sourceLocation",
+}
+
+// errors marshal to {} otherwise
+func fieldValue(v any) any {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case fmt.Stringer:
+		if _, ok := v.(json.Marshaler); !ok {
+			return t.String()
+		}
+	}
+	return v
+}
+
+func marshalNoEscape(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+type SourceLocation struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,string,omitempty"`
+	Function string `json:"function,omitempty"`
+}
+
+func sourceLocation(pc uintptr) *SourceLocation {
+	if pc == 0 {
+		return nil
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return &SourceLocation{File: frame.File, Line: frame.Line, Function: frame.Function}
+}
+
+// HttpRequest is the request a log entry is about, as understood by Cloud Logging.
+type HttpRequest struct {
+	RequestMethod string `json:"requestMethod,omitempty"`
+	RequestUrl    string `json:"requestUrl,omitempty"`
+	RequestSize   int64  `json:"requestSize,string,omitempty"`
+	Status        int    `json:"status,omitempty"`
+	ResponseSize  int64  `json:"responseSize,string,omitempty"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	RemoteIp      string `json:"remoteIp,omitempty"`
+	ServerIp      string `json:"serverIp,omitempty"`
+	Referer       string `json:"referer,omitempty"`
+	// Latency is formatted as a duration in seconds, e.g. "0.250s" (see SetLatency).
+	Latency  string `json:"latency,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// NewHttpRequest fills in the request fields from r. Status, ResponseSize and
+// Latency are left for the caller to set once the response is written.
+func NewHttpRequest(r *http.Request) *HttpRequest {
+	req := &HttpRequest{
+		RequestMethod: r.Method,
+		RequestUrl:    r.URL.String(),
+		RequestSize:   max(r.ContentLength, 0),
+		UserAgent:     r.UserAgent(),
+		RemoteIp:      r.RemoteAddr,
+		Referer:       r.Referer(),
+		Protocol:      r.Proto,
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.RemoteIp = ip
+	}
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		req.RemoteIp = strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	return req
+}
+
+func (r *HttpRequest) SetLatency(d time.Duration) {
+	r.Latency = strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
 }
 
 type GcpLogger struct {
 	out, err io.Writer
 	mu       sync.Mutex
 	trace    json.RawMessage
+	spanID   string
+	sampled  bool
+	insertID string
+	labels   map[string]string
+	fields   map[string]any
+	httpReq  *HttpRequest
+	// level is the minimum severity written; nil uses the root's.
+	level *SeverityVar
+	name  string
+	// root is the logger whose mutex serializes writes (nil for the root itself)
+	root *GcpLogger
+
+	// root only
+	lvl        SeverityVar
+	regMu      sync.Mutex
+	components map[string]*SeverityVar
+	format     atomic.Int32
+	async      atomic.Pointer[asyncWriter]
+	sampler    atomic.Pointer[sampler]
+	service    atomic.Pointer[ServiceContext]
+	// whether out and err are terminals
+	ttyOnce [2]sync.Once
+	tty     [2]bool
+}
+
+// NewGcpLogger returns a logger writing entries below Error to out and the rest to err.
+// Nil writers default to os.Stdout and os.Stderr, respectively.
+func NewGcpLogger(out, err io.Writer) *GcpLogger {
+	return &GcpLogger{out: out, err: err}
+}
+
+func (l *GcpLogger) clone() *GcpLogger {
+	return &GcpLogger{
+		out:      l.out,
+		err:      l.err,
+		trace:    l.trace,
+		spanID:   l.spanID,
+		sampled:  l.sampled,
+		insertID: l.insertID,
+		labels:   l.labels,
+		fields:   l.fields,
+		httpReq:  l.httpReq,
+		level:    l.level,
+		name:     l.name,
+		root:     l.sink(),
+	}
+}
+
+func (l *GcpLogger) sink() *GcpLogger {
+	if l.root != nil {
+		return l.root
+	}
+	return l
+}
+
+// With returns a logger that adds the key-value pairs to the jsonPayload of every entry.
+func (l *GcpLogger) With(kv ...any) *GcpLogger {
+	c := l.clone()
+	c.fields = make(map[string]any, len(l.fields)+len(kv)/2)
+	maps.Copy(c.fields, l.fields)
+	for len(kv) > 0 {
+		if len(kv) == 1 {
+			c.fields["!BADKEY"] = kv[0]
+			break
+		}
+		c.fields[fmt.Sprint(kv[0])] = kv[1]
+		kv = kv[2:]
+	}
+	return c
+}
+
+// WithLabels returns a logger that adds labels to every entry.
+func (l *GcpLogger) WithLabels(labels map[string]string) *GcpLogger {
+	c := l.clone()
+	c.labels = make(map[string]string, len(l.labels)+len(labels))
+	maps.Copy(c.labels, l.labels)
+	maps.Copy(c.labels, labels)
+	return c
+}
+
+func (l *GcpLogger) WithSpanID(spanID string) *GcpLogger {
+	c := l.clone()
+	c.spanID = spanID
+	return c
+}
+
+// WithInsertID sets the insertId used by Cloud Logging to deduplicate entries.
+// It should be unique per entry.
+func (l *GcpLogger) WithInsertID(insertID string) *GcpLogger {
+	c := l.clone()
+	c.insertID = insertID
+	return c
+}
+
+func (l *GcpLogger) WithHttpRequest(r *HttpRequest) *GcpLogger {
+	c := l.clone()
+	c.httpReq = r
+	return c
 }
 
 func (l *GcpLogger) Print(v ...any) {
@@ -135,12 +501,57 @@ func (l *GcpLogger) writer(s Severity) io.Writer {
 }
 
 func logGCP(s Severity, l *GcpLogger, msg string) string {
-	entry := Entry{msg, s, l.trace}
-	fmt.Println(entry)
-	enc := json.NewEncoder(l.writer(s))
-	enc.SetEscapeHTML(false)
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	_ = enc.Encode(entry)
+	if !l.Enabled(s) {
+		return msg
+	}
+
+	// skip runtime.Callers, logGCP and the GcpLogger method
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+
+	l.write(l.entry(s, msg, pcs[0]))
 	return msg
 }
+
+func (l *GcpLogger) entry(s Severity, msg string, pc uintptr) Entry {
+	return Entry{
+		Message:        msg,
+		Severity:       s,
+		Time:           time.Now(),
+		Trace:          l.trace,
+		SpanID:         l.spanID,
+		TraceSampled:   l.sampled,
+		InsertID:       l.insertID,
+		Labels:         l.labels,
+		SourceLocation: sourceLocation(pc),
+		HttpRequest:    l.httpReq,
+		Fields:         l.fields,
+	}
+}
+
+func (l *GcpLogger) write(entry Entry) {
+	root := l.sink()
+	if sp := root.sampler.Load(); sp != nil && !sp.keep(entry.Severity, entry.Message) {
+		return
+	}
+
+	var b []byte
+	if root.console(entry.Severity) {
+		b = []byte(renderConsole(entry, root.isTTY(entry.Severity) && !noColor()))
+	} else {
+		var err error
+		if b, err = marshalNoEscape(entry); err != nil {
+			return
+		}
+		b = append(b, '\n')
+	}
+
+	w := l.writer(entry.Severity)
+	if aw := root.async.Load(); aw != nil && aw.enqueue(w, b) {
+		return
+	}
+
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,193 @@
+package p
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var ret []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		m := map[string]any{}
+		require.NoError(t, json.Unmarshal([]byte(line), &m), line)
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+func TestGcpLogger_Writers(t *testing.T) {
+	var out, errOut bytes.Buffer
+	l := NewGcpLogger(&out, &errOut)
+
+	l.Info("hello %s", "world")
+	l.Error("oops")
+
+	got := decodeLines(t, &out)
+	require.Len(t, got, 1)
+	require.Equal(t, "hello world", got[0]["message"])
+	require.Equal(t, "INFO", got[0]["severity"])
+
+	got = decodeLines(t, &errOut)
+	require.Len(t, got, 1)
+	require.Equal(t, "ERROR", got[0]["severity"])
+}
+
+func TestGcpLogger_StructuredFields(t *testing.T) {
+	var out bytes.Buffer
+	l := NewGcpLogger(&out, &out)
+
+	req := NewHttpRequest(httptest.NewRequest("POST", "/push?x=1", strings.NewReader("data")))
+	req.Status = 200
+	req.SetLatency(1500 * time.Millisecond)
+
+	l.With("rows", 3, "err", errors.New("boom"), "message", "dropped", "dangling").
+		WithLabels(map[string]string{"component": "push"}).
+		WithSpanID("000000000000004a").
+		WithInsertID("abc").
+		WithHttpRequest(req).
+		Warning("<b>partial</b>")
+
+	got := decodeLines(t, &out)
+	require.Len(t, got, 1)
+
+	e := got[0]
+	require.Equal(t, "<b>partial</b>", e["message"])
+	require.Equal(t, float64(3), e["rows"])
+	require.Equal(t, "boom", e["err"])
+	require.Equal(t, "dangling", e["!BADKEY"])
+	require.Equal(t, "000000000000004a", e["logging.googleapis.com/spanId"])
+	require.Equal(t, "abc", e["logging.googleapis.com/insertId"])
+	require.Equal(t, map[string]any{"component": "push"}, e["logging.googleapis.com/labels"])
+
+	httpReq := e["httpRequest"].(map[string]any)
+	require.Equal(t, "POST", httpReq["requestMethod"])
+	require.Equal(t, "4", httpReq["requestSize"])
+	require.Equal(t, "1.5s", httpReq["latency"])
+	require.Equal(t, "192.0.2.1", httpReq["remoteIp"])
+
+	loc := e["logging.googleapis.com/sourceLocation"].(map[string]any)
+	require.True(t, strings.HasSuffix(loc["file"].(string), "logging_test.go"), loc["file"])
+	require.Contains(t, loc["function"], "TestGcpLogger_StructuredFields")
+}
+
+func TestGcpLogger_WithDoesNotMutateParent(t *testing.T) {
+	var out bytes.Buffer
+	l := NewGcpLogger(&out, &out).With("a", 1)
+	_ = l.With("b", 2).WithLabels(map[string]string{"x": "y"})
+
+	l.Info("parent")
+	got := decodeLines(t, &out)
+	require.Equal(t, float64(1), got[0]["a"])
+	require.NotContains(t, got[0], "b")
+	require.NotContains(t, got[0], "logging.googleapis.com/labels")
+}
+
+func TestGcpLogger_ConsoleFormat(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+
+	var out bytes.Buffer
+	l := NewGcpLogger(&out, &out)
+	require.False(t, l.console(Info))
+
+	l.SetFormat(FormatConsole)
+	// not a terminal, so no colors
+	l.Info("plain")
+	require.NotContains(t, out.String(), "\x1b[")
+
+	out.Reset()
+	l = NewGcpLogger(&out, &out)
+	l.SetFormat(FormatConsole)
+	l.ttyOnce[0].Do(func() { l.tty[0] = true })
+	l.Named("push").With("rows", 3, "note", "two words").Warning("slow")
+
+	line := out.String()
+	require.True(t, strings.HasSuffix(line, "\n"))
+	require.Contains(t, line, Colorize(33, "WARNING")+"   slow")
+	require.Contains(t, line, Colorize(90, "component=")+"push")
+	require.Contains(t, line, Colorize(90, "note=")+`"two words"`)
+	require.Contains(t, line, Colorize(90, "rows=")+"3")
+	require.Contains(t, line, "caller=logging_test.go:")
+
+	out.Reset()
+	t.Setenv("NO_COLOR", "1")
+	l.Info("plain")
+	require.NotContains(t, out.String(), "\x1b[")
+	require.Regexp(t, `^\d\d:\d\d:\d\d\.\d{3} INFO      plain\s+caller=logging_test.go:\d+\n$`, out.String())
+
+	out.Reset()
+	l.WithTrace("proj", TraceContext{TraceID: "0af7651916cd43dd8448eb211c80319c"}).Info("traced")
+	require.Contains(t, out.String(), " trace=0af7651916cd43dd8448eb211c80319c caller=")
+
+	// stderr is checked on its own
+	out.Reset()
+	t.Setenv("NO_COLOR", "")
+	l.Info("colored")
+	require.Contains(t, out.String(), "\x1b[")
+	out.Reset()
+	l.Error("plain")
+	require.NotContains(t, out.String(), "\x1b[")
+	l.SetFormat(FormatAuto)
+	out.Reset()
+	l.Error("json")
+	require.Equal(t, "json", decodeLines(t, &out)[0]["message"])
+
+	out.Reset()
+	l.SetFormat(FormatJSON)
+	l.Info("json")
+	require.Equal(t, "json", decodeLines(t, &out)[0]["message"])
+}
+
+func TestSeverity_RoundTrip(t *testing.T) {
+	for s := Debug; s <= Emergency; s++ {
+		b, err := json.Marshal(s)
+		require.NoError(t, err)
+
+		var got Severity
+		require.NoError(t, json.Unmarshal(b, &got))
+		require.Equal(t, s, got)
+
+		text, err := s.MarshalText()
+		require.NoError(t, err)
+		require.Equal(t, s.String(), string(text))
+		require.NoError(t, got.UnmarshalText([]byte(strings.ToLower(string(text)))))
+		require.Equal(t, s, got)
+	}
+
+	var s Severity
+	require.NoError(t, json.Unmarshal([]byte(`500`), &s))
+	require.Equal(t, Error, s)
+	require.NoError(t, json.Unmarshal([]byte(`3`), &s))
+	require.Equal(t, Notice, s)
+	require.NoError(t, json.Unmarshal([]byte(`"DEFAULT"`), &s))
+	require.Equal(t, Severity(0), s)
+	require.Error(t, json.Unmarshal([]byte(`"LOUD"`), &s))
+	require.Error(t, json.Unmarshal([]byte(`900`), &s))
+
+	require.NoError(t, s.Set("warn"))
+	require.Equal(t, Warning, s)
+	require.Equal(t, "Severity(42)", Severity(42).String())
+
+	b, err := json.Marshal(Severity(0))
+	require.NoError(t, err)
+	require.Equal(t, `"DEFAULT"`, string(b))
+	require.NoError(t, json.Unmarshal(b, &s))
+	require.Equal(t, Severity(0), s)
+
+	var cfg struct {
+		Level Severity `json:"level"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"level":"critical"}`), &cfg))
+	require.Equal(t, Critical, cfg.Level)
+}
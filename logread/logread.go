@@ -0,0 +1,80 @@
+// Package logread parses the JSON lines written by p.GcpLogger back into entries,
+// for use in tests and tooling.
+package logread
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/s-hammon/p"
+)
+
+type Reader struct {
+	br   *bufio.Reader
+	line int
+	// Lenient skips lines that aren't valid entries (e.g. output of other
+	// loggers interleaved with GcpLogger's) instead of returning an error.
+	Lenient bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Next returns the next entry, skipping blank lines. It returns io.EOF when
+// the input is exhausted.
+func (r *Reader) Next() (p.Entry, error) {
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return p.Entry{}, err
+		}
+		r.line++
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var e p.Entry
+		if uerr := json.Unmarshal(line, &e); uerr != nil {
+			if r.Lenient {
+				continue
+			}
+			return p.Entry{}, fmt.Errorf("line %d: %w", r.line, uerr)
+		}
+		return e, nil
+	}
+}
+
+// Line returns the number of the last line read.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// ReadAll reads every entry in r.
+func ReadAll(r io.Reader) ([]p.Entry, error) {
+	lr := NewReader(r)
+	var entries []p.Entry
+	for {
+		e, err := lr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+// AtLeast filters entries down to those of severity s or above.
+func AtLeast(entries []p.Entry, s p.Severity) []p.Entry {
+	return p.Filter(entries, func(e p.Entry) bool {
+		return e.Severity >= s
+	})
+}
@@ -0,0 +1,56 @@
+package logread
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/s-hammon/p"
+)
+
+func TestReadAll(t *testing.T) {
+	var out bytes.Buffer
+	l := p.NewGcpLogger(&out, &out)
+	l.SetFormat(p.FormatJSON)
+
+	l.With("rows", 3).WithLabels(map[string]string{"component": "push"}).Info("appended")
+	out.WriteString("\n")
+	l.ReportError(errors.New("boom"), "flush")
+
+	entries, err := ReadAll(&out)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	require.Equal(t, "appended", entries[0].Message)
+	require.Equal(t, p.Info, entries[0].Severity)
+	require.Equal(t, map[string]any{"rows": float64(3)}, entries[0].Fields)
+	require.Equal(t, "push", entries[0].Labels["component"])
+	require.NotNil(t, entries[0].SourceLocation)
+	require.False(t, entries[0].Time.IsZero())
+
+	require.Equal(t, p.Error, entries[1].Severity)
+	require.Equal(t, "flush: boom", entries[1].Message)
+	require.Contains(t, entries[1].StackTrace, "TestReadAll")
+
+	require.Len(t, AtLeast(entries, p.Warning), 1)
+}
+
+func TestReader_Errors(t *testing.T) {
+	in := "{\"message\":\"ok\",\"severity\":\"INFO\"}\nnot json\n{\"message\":\"also ok\",\"severity\":400}\n"
+
+	_, err := ReadAll(strings.NewReader(in))
+	require.ErrorContains(t, err, "line 2")
+
+	r := NewReader(strings.NewReader(in))
+	r.Lenient = true
+	e, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "ok", e.Message)
+	e, err = r.Next()
+	require.NoError(t, err)
+	require.Equal(t, p.Warning, e.Severity)
+	require.Equal(t, 3, r.Line())
+}
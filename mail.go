@@ -0,0 +1,224 @@
+package p
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	texttemplate "text/template"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Email builds a message for EmailClient. Methods return the receiver for
+// chaining; errors (e.g. from templates) are collected and returned by Build.
+//
+//	msg, err := p.NewEmail().
+//		To("team@example.com").
+//		Subject("Daily load").
+//		HTMLTemplate(tmpl, stats).
+//		AttachFunc("rows.csv", func(w io.Writer) error {
+//			cw := csv.NewWriter(w)
+//			cw.WriteAll(rows)
+//			return cw.Error()
+//		}).
+//		Build()
+type Email struct {
+	from              string
+	to, cc, bcc       []string
+	replyTo           []string
+	subject           string
+	text, html        string
+	attached, inlined []mailFile
+
+	errs []error
+}
+
+type mailFile struct {
+	name string
+	copy func(io.Writer) error
+}
+
+func NewEmail() *Email {
+	return &Email{}
+}
+
+// From sets the sender, the client's configured sender if empty.
+func (e *Email) From(addr string) *Email {
+	e.from = addr
+	return e
+}
+
+func (e *Email) To(addrs ...string) *Email {
+	e.to = append(e.to, addrs...)
+	return e
+}
+
+func (e *Email) Cc(addrs ...string) *Email {
+	e.cc = append(e.cc, addrs...)
+	return e
+}
+
+// Bcc adds recipients that don't appear in the message headers.
+func (e *Email) Bcc(addrs ...string) *Email {
+	e.bcc = append(e.bcc, addrs...)
+	return e
+}
+
+func (e *Email) ReplyTo(addrs ...string) *Email {
+	e.replyTo = append(e.replyTo, addrs...)
+	return e
+}
+
+func (e *Email) Subject(s string) *Email {
+	e.subject = s
+	return e
+}
+
+// Text sets the plain text body. With an HTML body too, it is sent as the alternative.
+func (e *Email) Text(s string) *Email {
+	e.text = s
+	return e
+}
+
+func (e *Email) HTML(s string) *Email {
+	e.html = s
+	return e
+}
+
+func (e *Email) TextTemplate(t *texttemplate.Template, data any) *Email {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		e.errs = append(e.errs, err)
+	}
+	return e.Text(buf.String())
+}
+
+// HTMLTemplate renders the HTML body, escaping data as html/template does.
+func (e *Email) HTMLTemplate(t *htmltemplate.Template, data any) *Email {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		e.errs = append(e.errs, err)
+	}
+	return e.HTML(buf.String())
+}
+
+// Attach attaches the file at path under its base name. The file is read
+// when the message is sent, but Build reports it if it can't be found.
+func (e *Email) Attach(path string) *Email {
+	e.checkFile(path)
+	return e.AttachFunc(filepath.Base(path), copyFile(path))
+}
+
+// AttachBytes attaches b as name. The content type is guessed from name's extension.
+func (e *Email) AttachBytes(name string, b []byte) *Email {
+	return e.AttachFunc(name, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// AttachFunc attaches the output of fn as name, e.g. a CSV written while the message is sent.
+func (e *Email) AttachFunc(name string, fn func(io.Writer) error) *Email {
+	e.attached = append(e.attached, mailFile{name: name, copy: fn})
+	return e
+}
+
+// Embed adds the image at path inline; reference it from the HTML body as
+// <img src="cid:NAME"> where NAME is the file's base name.
+func (e *Email) Embed(path string) *Email {
+	e.checkFile(path)
+	e.inlined = append(e.inlined, mailFile{name: filepath.Base(path), copy: copyFile(path)})
+	return e
+}
+
+// EmbedBytes is Embed for an in-memory image, referenced as cid:name.
+func (e *Email) EmbedBytes(name string, b []byte) *Email {
+	e.inlined = append(e.inlined, mailFile{name: name, copy: func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	}})
+	return e
+}
+
+// checkFile records an error for Build if path isn't a readable file.
+func (e *Email) checkFile(path string) {
+	fi, err := os.Stat(path)
+	if err == nil && fi.IsDir() {
+		err = fmt.Errorf("%s is a directory", path)
+	}
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("email: %w", err))
+	}
+}
+
+func copyFile(path string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	}
+}
+
+// Build returns the message, or the errors collected while building it.
+func (e *Email) Build() (*gomail.Message, error) {
+	errs := slices.Clone(e.errs)
+	if len(e.to)+len(e.cc)+len(e.bcc) == 0 {
+		errs = append(errs, errors.New("email: no recipients"))
+	}
+	for _, f := range e.attached {
+		if f.name == "" {
+			errs = append(errs, errors.New("email: attachment without a name"))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	m := gomail.NewMessage()
+	if e.from != "" {
+		m.SetHeader("From", e.from)
+	}
+	for field, addrs := range map[string][]string{"To": e.to, "Cc": e.cc, "Bcc": e.bcc, "Reply-To": e.replyTo} {
+		if len(addrs) > 0 {
+			m.SetHeader(field, addrs...)
+		}
+	}
+	m.SetHeader("Subject", e.subject)
+
+	switch {
+	case e.text != "" && e.html != "":
+		m.SetBody("text/plain", e.text)
+		m.AddAlternative("text/html", e.html)
+	case e.html != "":
+		m.SetBody("text/html", e.html)
+	default:
+		m.SetBody("text/plain", e.text)
+	}
+
+	for _, f := range e.attached {
+		m.Attach(f.name, gomail.SetCopyFunc(f.copy))
+	}
+	for _, f := range e.inlined {
+		m.Embed(f.name, gomail.SetCopyFunc(f.copy))
+	}
+	return m, nil
+}
+
+// SendEmail builds and sends e.
+func (c *EmailClient) SendEmail(ctx context.Context, e *Email) error {
+	m, err := e.Build()
+	if err != nil {
+		return err
+	}
+	return c.Send(ctx, m)
+}
@@ -0,0 +1,135 @@
+package p
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mimeParts returns the decoded leaves of a MIME message by content type, or
+// "multipart type:file name" for files, whose Content-ID is appended after a "|".
+func mimeParts(t *testing.T, header map[string][]string, body io.Reader) map[string]string {
+	t.Helper()
+
+	got := make(map[string]string)
+	var walk func(contentType string, r io.Reader)
+	walk = func(contentType string, r io.Reader) {
+		mt, params, err := mime.ParseMediaType(contentType)
+		require.NoError(t, err)
+		if !strings.HasPrefix(mt, "multipart/") {
+			b, err := io.ReadAll(r)
+			require.NoError(t, err)
+			got[mt] = string(b)
+			return
+		}
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return
+			}
+			require.NoError(t, err)
+			if name := part.FileName(); name != "" {
+				var r io.Reader = part
+				if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+					r = base64.NewDecoder(base64.StdEncoding, part)
+				}
+				b, err := io.ReadAll(r)
+				require.NoError(t, err)
+				got[mt+":"+name] = string(b) + "|" + part.Header.Get("Content-ID")
+				continue
+			}
+			walk(part.Header.Get("Content-Type"), part)
+		}
+	}
+	walk(header["Content-Type"][0], body)
+	return got
+}
+
+func TestEmail_Build(t *testing.T) {
+	logo := filepath.Join(t.TempDir(), "logo.png")
+	require.NoError(t, os.WriteFile(logo, []byte("png"), 0o600))
+
+	data := struct{ Table, Note string }{"orders", "<late>"}
+	html := htmltemplate.Must(htmltemplate.New("").Parse(`<img src="cid:logo.png"><p>{{.Table}}: {{.Note}}</p>`))
+	text := texttemplate.Must(texttemplate.New("").Parse(`{{.Table}}: {{.Note}}`))
+
+	m, err := NewEmail().
+		From("jobs@example.com").
+		To("a@example.com").
+		Cc("b@example.com").
+		Bcc("c@example.com").
+		ReplyTo("oncall@example.com").
+		Subject("Daily load").
+		HTMLTemplate(html, data).
+		TextTemplate(text, data).
+		AttachBytes("rows.csv", []byte("id,n\n1,2\n")).
+		Embed(logo).
+		Build()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = m.WriteTo(&buf)
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(&buf)
+	require.NoError(t, err)
+	require.Equal(t, "oncall@example.com", msg.Header.Get("Reply-To"))
+	require.Equal(t, "b@example.com", msg.Header.Get("Cc"))
+	require.Empty(t, msg.Header.Get("Bcc"))
+
+	got := mimeParts(t, msg.Header, msg.Body)
+	require.Equal(t, "orders: <late>", got["text/plain"])
+	require.Equal(t, `<img src="cid:logo.png"><p>orders: &lt;late&gt;</p>`, got["text/html"])
+	require.Equal(t, "png|<logo.png>", got["multipart/related:logo.png"])
+	require.Equal(t, "id,n\n1,2\n|", got["multipart/mixed:rows.csv"])
+}
+
+func TestEmail_BuildErrors(t *testing.T) {
+	bad := texttemplate.Must(texttemplate.New("").Parse(`{{.Missing}}`))
+	_, err := NewEmail().TextTemplate(bad, struct{}{}).Build()
+	require.ErrorContains(t, err, "Missing")
+	require.ErrorContains(t, err, "no recipients")
+
+	// Build can be called again, and missing files are reported
+	e := NewEmail().Attach(filepath.Join(t.TempDir(), "missing.csv")).Embed(t.TempDir())
+	for range 2 {
+		_, err = e.Build()
+		require.ErrorIs(t, err, os.ErrNotExist)
+		require.ErrorContains(t, err, "is a directory")
+		require.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 3)
+	}
+}
+
+func TestEmailClient_SendEmail(t *testing.T) {
+	srv, _ := newFakeSMTP(t, false)
+	cfg := srv.config()
+	cfg.TLS = TLSNone
+	cfg.From = "jobs@example.com"
+	c, err := NewEmailClient(cfg)
+	require.NoError(t, err)
+
+	e := NewEmail().To("a@example.com").Bcc("c@example.com").Subject("hi").Text("hello").
+		AttachFunc("report.txt", func(w io.Writer) error {
+			_, err := io.WriteString(w, "streamed")
+			return err
+		})
+	require.NoError(t, c.SendEmail(context.Background(), e))
+
+	got := srv.messages()
+	require.Len(t, got, 1)
+	require.ElementsMatch(t, []string{"a@example.com", "c@example.com"}, got[0].To)
+	require.Contains(t, got[0].Data, `filename="report.txt"`)
+}
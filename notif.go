@@ -1,30 +1,199 @@
 package p
 
 import (
-	"crypto/tls"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
 	"os"
-
-	"gopkg.in/gomail.v2"
-)
-
-var (
-	NotifEmail = os.Getenv("NOTIFICATION_EMAIL")
-	SMTPServer = "smtp.gmail.com"
-	SMTPPort   = 465
-	SMTPUser   = os.Getenv("SMTP_USER")
-	SMTPPass   = os.Getenv("SMTP_PASS")
+	"strings"
+	"sync"
+	"time"
 )
 
+// SendEmail sends an HTML email with the client configured by EmailConfigFromEnv.
 func SendEmail(from string, to []string, subject, content string) error {
-	m := gomail.NewMessage()
-	m.SetHeader("From", from)
-	m.SetHeader("To", to...)
-	m.SetHeader("Subject", subject)
+	cfg, err := EmailConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	c, err := NewEmailClient(cfg)
+	if err != nil {
+		return err
+	}
+	return c.SendHTML(context.Background(), from, to, subject, content)
+}
+
+// Notification is an alert sent through a Notifier.
+type Notification struct {
+	Subject  string   `json:"subject"`
+	Body     string   `json:"body,omitempty"`
+	Severity Severity `json:"severity"`
+	// Key identifies notifications about the same thing, e.g. "push:orders".
+	Key    string         `json:"key,omitempty"`
+	Fields map[string]any `json:"fields,omitempty"`
+	Time   time.Time      `json:"time,omitzero"`
+}
+
+// Text renders n as plain text: the body followed by the fields as sorted key: value lines.
+func (n Notification) Text() string {
+	var b strings.Builder
+	b.WriteString(n.Body)
+	if len(n.Fields) > 0 && n.Body != "" {
+		b.WriteString("\n\n")
+	}
+	for _, k := range sortedKeys(n.Fields) {
+		b.WriteString(k + ": " + consoleValue(n.Fields[k]) + "\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// MarshalJSON renders errors and Stringers in Fields as strings, as the logger does.
+func (n Notification) MarshalJSON() ([]byte, error) {
+	type plain Notification
+	p := plain(n)
+	if len(n.Fields) > 0 {
+		p.Fields = make(map[string]any, len(n.Fields))
+		for k, v := range n.Fields {
+			p.Fields[k] = fieldValue(v)
+		}
+	}
+	return json.Marshal(p)
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+type NotifierFunc func(ctx context.Context, n Notification) error
+
+func (f NotifierFunc) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+// EmailNotifier sends notifications by email. Client defaults to one
+// configured by EmailConfigFromEnv, To to $NOTIFICATION_EMAIL (or NotifEmail).
+type EmailNotifier struct {
+	Client *EmailClient
+	From   string
+	To     []string
+}
+
+func (e *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	to := e.To
+	if len(to) == 0 {
+		if addr := Coalesce(os.Getenv("NOTIFICATION_EMAIL"), NotifEmail); addr != "" {
+			to = []string{addr}
+		}
+	}
+	if len(to) == 0 {
+		return errors.New("email: no recipients: set To or NOTIFICATION_EMAIL")
+	}
+
+	c := e.Client
+	if c == nil {
+		cfg, err := EmailConfigFromEnv()
+		if err != nil {
+			return err
+		}
+		if c, err = NewEmailClient(cfg); err != nil {
+			return err
+		}
+	}
+	subject := n.Subject
+	if name, ok := n.Severity.name(); ok {
+		subject = "[" + name + "] " + subject
+	}
+	body := "<pre>" + html.EscapeString(n.Text()) + "</pre>"
+
+	return c.SendHTML(ctx, e.From, to, subject, body)
+}
+
+// WriterNotifier writes each notification as a line of JSON to W,
+// e.g. os.Stdout or a file opened with OpenFileNotifier.
+type WriterNotifier struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+func NewWriterNotifier(w io.Writer) *WriterNotifier {
+	return &WriterNotifier{W: w}
+}
+
+// OpenFileNotifier appends notifications to the file at path, creating it if needed.
+// Close the returned file when done.
+func OpenFileNotifier(path string) (*WriterNotifier, *os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewWriterNotifier(f), f, nil
+}
+
+func (w *WriterNotifier) Notify(_ context.Context, n Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.W.Write(append(b, '\n'))
+	return err
+}
+
+// Channel is a named Notifier that only receives notifications of MinSeverity or above.
+type Channel struct {
+	Name        string
+	Notifier    Notifier
+	MinSeverity Severity
+}
+
+// Dispatcher fans notifications out to its channels concurrently.
+// It is itself a Notifier, so dispatchers can be nested.
+type Dispatcher struct {
+	channels []Channel
+}
+
+func NewDispatcher(channels ...Channel) *Dispatcher {
+	return &Dispatcher{channels: channels}
+}
+
+func (d *Dispatcher) Add(c Channel) *Dispatcher {
+	d.channels = append(d.channels, c)
+	return d
+}
 
-	m.SetBody("text/html", content)
+// Notify sends n to every channel accepting its severity, stamping n.Time if unset.
+// Every channel is tried; the returned error joins the failures, prefixed by channel name.
+func (d *Dispatcher) Notify(ctx context.Context, n Notification) error {
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
 
-	d := gomail.NewDialer(SMTPServer, SMTPPort, SMTPUser, SMTPPass)
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, c := range d.channels {
+		if n.Severity < c.MinSeverity {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Notifier.Notify(ctx, n); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", Coalesce(c.Name, fmt.Sprintf("%T", c.Notifier)), err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
 
-	return d.DialAndSend(m)
+	return errors.Join(errs...)
 }
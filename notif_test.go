@@ -0,0 +1,150 @@
+package p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		got  []map[string]any
+		auth string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		m := map[string]any{}
+		require.NoError(t, json.Unmarshal(b, &m))
+
+		mu.Lock()
+		got = append(got, m)
+		auth = r.Header.Get("Authorization")
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	n := Notification{
+		Subject:  "push failed",
+		Body:     "3 rows rejected",
+		Severity: Error,
+		Fields:   map[string]any{"table": "orders"},
+	}
+	ctx := context.Background()
+
+	slack := &WebhookNotifier{URL: srv.URL, Style: WebhookSlack, Header: http.Header{"Authorization": {"Bearer x"}}}
+	require.NoError(t, slack.Notify(ctx, n))
+	require.Equal(t, "*ERROR: push failed*\n3 rows rejected\n\ntable: orders", got[0]["text"])
+	require.Equal(t, "Bearer x", auth)
+
+	teams := &WebhookNotifier{URL: srv.URL, Style: WebhookTeams}
+	require.NoError(t, teams.Notify(ctx, n))
+	require.Equal(t, "MessageCard", got[1]["@type"])
+	require.Equal(t, "D32F2F", got[1]["themeColor"])
+	facts := got[1]["sections"].([]any)[0].(map[string]any)["facts"].([]any)
+	require.Equal(t, map[string]any{"name": "table", "value": "orders"}, facts[0])
+
+	generic := &WebhookNotifier{URL: srv.URL}
+	require.NoError(t, generic.Notify(ctx, n))
+	require.Equal(t, "ERROR", got[2]["severity"])
+	require.Equal(t, "push failed", got[2]["subject"])
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no_service", http.StatusNotFound)
+	})
+	require.ErrorContains(t, generic.Notify(ctx, n), "404 Not Found: no_service")
+}
+
+func TestDispatcher(t *testing.T) {
+	var all, errs bytes.Buffer
+	failing := NotifierFunc(func(context.Context, Notification) error {
+		return errors.New("down")
+	})
+
+	path := filepath.Join(t.TempDir(), "alerts.jsonl")
+	file, f, err := OpenFileNotifier(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	d := NewDispatcher(
+		Channel{Name: "all", Notifier: NewWriterNotifier(&all)},
+		Channel{Name: "errors", Notifier: NewWriterNotifier(&errs), MinSeverity: Error},
+		Channel{Name: "file", Notifier: file},
+	).Add(Channel{Name: "pager", Notifier: failing, MinSeverity: Critical})
+
+	ctx := context.Background()
+	require.NoError(t, d.Notify(ctx, Notification{Subject: "slow", Severity: Warning}))
+	require.NoError(t, d.Notify(ctx, Notification{Subject: "failed", Severity: Error}))
+	require.EqualError(t, d.Notify(ctx, Notification{Subject: "down", Severity: Critical}), "pager: down")
+
+	require.Len(t, decodeLines(t, &all), 3)
+	got := decodeLines(t, &errs)
+	require.Len(t, got, 2)
+	require.Equal(t, "failed", got[0]["subject"])
+
+	ts, err := time.Parse(time.RFC3339Nano, got[0]["time"].(string))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), ts, time.Minute)
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, decodeLines(t, bytes.NewBuffer(b)), 3)
+}
+
+func TestNotifiers_ZeroSeverity(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []map[string]any
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		mu.Lock()
+		bodies = append(bodies, m)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	smtp, _ := newFakeSMTP(t, false)
+	cfg := smtp.config()
+	cfg.TLS = TLSNone
+	client, err := NewEmailClient(cfg)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	file, f, err := OpenFileNotifier(filepath.Join(t.TempDir(), "alerts.jsonl"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	notifiers := map[string]Notifier{
+		"writer": NewWriterNotifier(&buf),
+		"file":   file,
+		"email":  &EmailNotifier{Client: client, From: "jobs@example.com", To: []string{"ops@example.com"}},
+	}
+	for _, style := range []WebhookStyle{WebhookGeneric, WebhookSlack, WebhookTeams, WebhookChat} {
+		notifiers[fmt.Sprintf("webhook %d", style)] = &WebhookNotifier{URL: srv.URL, Style: style}
+	}
+
+	n := Notification{Subject: "x", Fields: map[string]any{"err": errors.New("boom")}}
+	for name, notifier := range notifiers {
+		require.NoError(t, notifier.Notify(context.Background(), n), name)
+	}
+
+	got := decodeLines(t, &buf)[0]
+	require.Equal(t, "DEFAULT", got["severity"])
+	require.Equal(t, map[string]any{"err": "boom"}, got["fields"])
+	require.Len(t, bodies, 4)
+	require.Len(t, smtp.messages(), 1)
+}
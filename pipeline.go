@@ -0,0 +1,179 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Pipeline runs Subprocesses like a shell pipeline (a | b | c) without a
+// shell: each stage's stdout is connected straight to the next stage's stdin.
+// The first stage reads from its StdInWriter and the last stage's stdout is
+// handled as usual (Capture, Print, Scanner). Stderr of every other stage is
+// captured for its StageResult.
+type Pipeline struct {
+	Stages []*Subprocess
+	// Context, if set, is given to every stage, so cancelling it stops them all.
+	Context context.Context
+	// AllowSIGPIPE doesn't count a stage killed by SIGPIPE as failed, for
+	// pipelines like "gsutil cat ... | head" where a later stage exits early.
+	AllowSIGPIPE bool
+
+	results []StageResult
+}
+
+// StageResult is the outcome of one stage of a Pipeline. ExitCode is -1 if
+// the stage was killed by a signal.
+type StageResult struct {
+	Command  string
+	ExitCode int
+	Err      error
+	Stderr   string
+}
+
+// PipelineError reports the last stage that failed, like bash's pipefail.
+type PipelineError struct {
+	Stage   int
+	Command string
+	Err     error
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("pipeline stage %d (%s): %v", e.Stage, e.Command, e.Err)
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+func NewPipeline(stages ...*Subprocess) *Pipeline {
+	return &Pipeline{Stages: stages}
+}
+
+func (pl *Pipeline) String() string {
+	parts := make([]string, len(pl.Stages))
+	for i, sp := range pl.Stages {
+		parts[i] = sp.String()
+	}
+	return strings.Join(parts, " | ")
+}
+
+// Start starts every stage. If one fails to start, those already started are killed.
+func (pl *Pipeline) Start() error {
+	if len(pl.Stages) == 0 {
+		return errors.New("empty pipeline")
+	}
+	for i, sp := range pl.Stages {
+		if sp.PTY {
+			return fmt.Errorf("pipeline stage %d (%s): PTY isn't supported in a pipeline", i, sp)
+		}
+	}
+	pl.results = nil
+
+	var (
+		started []*Subprocess
+		prev    *os.File // read end of the previous stage's stdout
+	)
+	for i, sp := range pl.Stages {
+		if pl.Context != nil {
+			sp.Context = pl.Context
+		}
+		sp.stdin, sp.stdout, sp.captureStderr = nil, nil, false
+		if prev != nil {
+			sp.stdin = prev
+		}
+
+		var r, w *os.File
+		if i < len(pl.Stages)-1 {
+			var err error
+			if r, w, err = os.Pipe(); err != nil {
+				if prev != nil {
+					prev.Close()
+				}
+				killAll(started)
+				return err
+			}
+			sp.stdout, sp.captureStderr = w, true
+		}
+
+		err := sp.Start()
+		// the stages have their own copies of the pipe ends now
+		if w != nil {
+			w.Close()
+		}
+		if prev != nil {
+			prev.Close()
+		}
+		prev = r
+
+		if err != nil {
+			if r != nil {
+				r.Close()
+			}
+			killAll(started)
+			return fmt.Errorf("start %s: %w", sp.Bin, err)
+		}
+		started = append(started, sp)
+	}
+	return nil
+}
+
+// killAll kills the process groups of sps, so their children die too.
+func killAll(sps []*Subprocess) {
+	for _, sp := range sps {
+		_ = sp.signal(sp.Cmd.Process, os.Kill)
+		<-sp.Done
+	}
+}
+
+// Wait waits for every stage and returns a *PipelineError for the last one
+// that failed, if any.
+func (pl *Pipeline) Wait() error {
+	pl.results = make([]StageResult, len(pl.Stages))
+
+	var err error
+	for i, sp := range pl.Stages {
+		res := StageResult{
+			Command:  sp.String(),
+			ExitCode: -1,
+			Err:      sp.Wait(),
+			Stderr:   sp.Stderr.String(),
+		}
+		if ps := sp.Cmd.ProcessState; ps != nil {
+			res.ExitCode = ps.ExitCode()
+		}
+		if res.Err != nil && pl.AllowSIGPIPE && i < len(pl.Stages)-1 && signaled(sp.Cmd, syscall.SIGPIPE) {
+			res.Err = nil
+		}
+
+		pl.results[i] = res
+		if res.Err != nil {
+			err = &PipelineError{Stage: i, Command: res.Command, Err: res.Err}
+		}
+	}
+	return err
+}
+
+func (pl *Pipeline) Run() error {
+	if err := pl.Start(); err != nil {
+		return err
+	}
+	return pl.Wait()
+}
+
+// Results returns the outcome of every stage, once Wait has returned.
+func (pl *Pipeline) Results() []StageResult {
+	return pl.results
+}
+
+func signaled(cmd *exec.Cmd, sig syscall.Signal) bool {
+	if cmd == nil || cmd.ProcessState == nil {
+		return false
+	}
+	ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	return ok && ws.Signaled() && ws.Signal() == sig
+}
@@ -0,0 +1,84 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newStages(t *testing.T, cmds ...[]string) []*Subprocess {
+	t.Helper()
+
+	var stages []*Subprocess
+	for _, c := range cmds {
+		sp, err := NewSubprocess(c[0], c[1:]...)
+		require.NoError(t, err)
+		stages = append(stages, sp)
+	}
+	stages[len(stages)-1].Capture = true
+	return stages
+}
+
+func TestPipeline(t *testing.T) {
+	pl := NewPipeline(newStages(t,
+		[]string{"printf", `c\nb\na\n`},
+		[]string{"sort"},
+		[]string{"head", "-n", "2"},
+	)...)
+	require.Equal(t, `printf c\nb\na\n | sort | head -n 2`, pl.String())
+
+	require.NoError(t, pl.Run())
+	require.Equal(t, "a\nb\n", pl.Stages[2].Stdout.String())
+	for _, res := range pl.Results() {
+		require.Equal(t, 0, res.ExitCode)
+	}
+}
+
+func TestPipeline_Pipefail(t *testing.T) {
+	pl := NewPipeline(newStages(t,
+		[]string{"sh", "-c", "echo oops >&2; echo x; exit 2"},
+		[]string{"cat"},
+	)...)
+
+	err := pl.Run()
+	var pe *PipelineError
+	require.True(t, errors.As(err, &pe), err)
+	require.Equal(t, 0, pe.Stage)
+	require.Equal(t, "x\n", pl.Stages[1].Stdout.String())
+
+	res := pl.Results()
+	require.Equal(t, 2, res[0].ExitCode)
+	require.Equal(t, "oops\n", res[0].Stderr)
+	require.Equal(t, 0, res[1].ExitCode)
+
+	// stages are left configured as they were
+	require.False(t, pl.Stages[0].Capture)
+	require.Equal(t, "oops\n", pl.Stages[0].Stderr.String())
+}
+
+func TestPipeline_SIGPIPE(t *testing.T) {
+	pl := NewPipeline(newStages(t, []string{"yes"}, []string{"head", "-n", "1"})...)
+	err := pl.Run()
+	require.Error(t, err)
+	require.Equal(t, -1, pl.Results()[0].ExitCode)
+
+	pl = NewPipeline(newStages(t, []string{"yes"}, []string{"head", "-n", "1"})...)
+	pl.AllowSIGPIPE = true
+	require.NoError(t, pl.Run())
+	require.Equal(t, "y\n", pl.Stages[1].Stdout.String())
+}
+
+func TestPipeline_Cancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	pl := NewPipeline(newStages(t, []string{"sleep", "10"}, []string{"cat"})...)
+	pl.Context = ctx
+
+	start := time.Now()
+	require.Error(t, pl.Run())
+	require.Less(t, time.Since(start), 5*time.Second)
+}
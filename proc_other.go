@@ -0,0 +1,30 @@
+//go:build !unix
+
+package p
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup signals only p itself, falling back to killing it where sig
+// isn't supported (e.g. os.Interrupt on Windows).
+func signalGroup(p *os.Process, sig os.Signal) error {
+	if sig == os.Kill {
+		return p.Kill()
+	}
+	if err := p.Signal(sig); err != nil {
+		return p.Kill()
+	}
+	return nil
+}
+
+func signalProcess(p *os.Process, sig os.Signal) error {
+	return signalGroup(p, sig)
+}
+
+func maxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
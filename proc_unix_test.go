@@ -0,0 +1,98 @@
+//go:build unix
+
+package p
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubprocess_CancelStopsGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pids := make(chan int, 1)
+	sp := newJob(t, "sh", "-c", "sleep 30 & echo $!; wait")
+	sp.Context = ctx
+	// background jobs of a non-interactive sh ignore SIGINT
+	sp.StopSignal = syscall.SIGTERM
+	sp.Scanner = func(stderr bool, text string) {
+		if pid, err := strconv.Atoi(text); err == nil {
+			pids <- pid
+		}
+	}
+	require.NoError(t, sp.Start())
+	grandchild := <-pids
+
+	start := time.Now()
+	cancel()
+	require.Error(t, sp.Wait())
+	require.Less(t, time.Since(start), 3*time.Second)
+
+	// the grandchild got the signal too (it may take a moment to be reaped by init)
+	require.Eventually(t, func() bool {
+		return errors.Is(syscall.Kill(grandchild, 0), syscall.ESRCH)
+	}, 3*time.Second, 20*time.Millisecond)
+}
+
+func TestSubprocess_NoProcessGroup(t *testing.T) {
+	sp := newJob(t, "sleep", "30")
+	require.NoError(t, sp.Start())
+	pgid, err := syscall.Getpgid(sp.Pid)
+	require.NoError(t, err)
+	require.Equal(t, sp.Pid, pgid)
+	require.NoError(t, sp.Stop())
+
+	sp.NoProcessGroup = true
+	sp.StopSignal = syscall.SIGTERM
+	require.NoError(t, sp.Start())
+	pgid, err = syscall.Getpgid(sp.Pid)
+	require.NoError(t, err)
+	require.Equal(t, syscall.Getpgrp(), pgid)
+
+	require.NoError(t, sp.Stop())
+	require.Equal(t, syscall.SIGTERM, sp.Result().Signal)
+}
+
+func TestSubprocess_StopEscalates(t *testing.T) {
+	ready := make(chan struct{})
+	sp := newJob(t, "sh", "-c", `trap "" INT TERM; echo ready; sleep 30 & wait`)
+	sp.StopSignal = syscall.SIGTERM
+	sp.GracePeriod = 200 * time.Millisecond
+	sp.Scanner = func(stderr bool, text string) {
+		if text == "ready" {
+			close(ready)
+		}
+	}
+	require.NoError(t, sp.Start())
+	<-ready
+
+	start := time.Now()
+	require.NoError(t, sp.Stop())
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	require.Less(t, time.Since(start), 3*time.Second)
+
+	require.EqualError(t, sp.Wait(), "signal: killed")
+	require.EqualError(t, sp.Wait(), "signal: killed")
+	require.NoError(t, sp.Stop())
+}
+
+func TestSubprocess_WaitDelay(t *testing.T) {
+	// the background sleep holds stdout open after sh exits
+	sp := newJob(t, "sh", "-c", "echo started; sleep 3 &")
+	sp.Capture = true
+	sp.WaitDelay = 100 * time.Millisecond
+	sp.GracePeriod = 100 * time.Millisecond
+
+	start := time.Now()
+	require.ErrorIs(t, sp.Run(), exec.ErrWaitDelay)
+	require.Less(t, time.Since(start), 2*time.Second)
+	require.Equal(t, "started\n", sp.Stdout.String())
+}
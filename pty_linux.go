@@ -0,0 +1,172 @@
+//go:build linux
+
+package p
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+type winsize struct {
+	Row, Col, Xpixel, Ypixel uint16
+}
+
+// openPTY allocates a pseudo-terminal, returning its master and slave ends.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlock := int32(0)
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlock: %w", err)
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("get number: %w", err)
+	}
+
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func setWinsize(f *os.File, rows, cols uint16) error {
+	ws := winsize{Row: rows, Col: cols}
+	return ioctl(f, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+// setupPTY connects the process's stdin, stdout and stderr to a new
+// pseudo-terminal, whose output is scanned as stdout.
+func (sp *Subprocess) setupPTY() error {
+	master, slave, err := openPTY()
+	if err != nil {
+		return fmt.Errorf("pty: %w", err)
+	}
+	sp.mu.Lock()
+	ws := sp.winsize
+	if ws != [2]uint16{} {
+		err = setWinsize(master, ws[0], ws[1])
+	}
+	if err != nil {
+		sp.mu.Unlock()
+		master.Close()
+		slave.Close()
+		return fmt.Errorf("pty: %w", err)
+	}
+	sp.pty, sp.ptySlave = master, slave
+	sp.mu.Unlock()
+
+	sp.Cmd.Stdin, sp.Cmd.Stdout, sp.Cmd.Stderr = slave, slave, slave
+	// the terminal needs a session of its own to control; as session leader
+	// the process also leads its own group, so Stop reaches its children
+	sp.Cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+
+	sp.StdoutReader = ptyReader{master}
+	sp.stdoutScanner = sp.newScanner(sp.StdoutReader)
+	sp.StderrReader, sp.stderrScanner = nil, nil
+
+	sp.StdInWriter, sp.stdinR = nil, nil
+	if sp.Stdin != nil {
+		sp.stdinR = &stdinReader{r: sp.Stdin}
+		sp.stdinW = &ptyWriter{f: master}
+	} else {
+		sp.StdInWriter = &ptyWriter{f: master}
+	}
+	return nil
+}
+
+// startedPTY closes the parent's copy of the slave once the process has it.
+func (sp *Subprocess) startedPTY(err error) {
+	sp.ptySlave.Close()
+	sp.ptySlave = nil
+	if err != nil {
+		sp.closePTY()
+	}
+}
+
+// closePTY closes the terminal once the process has exited.
+func (sp *Subprocess) closePTY() {
+	sp.mu.Lock()
+	master := sp.pty
+	sp.pty = nil
+	sp.mu.Unlock()
+	if master != nil {
+		master.Close()
+	}
+}
+
+// ptyReader reads the terminal's output. Reads fail with EIO once the
+// process and everything it started have closed the terminal, which is EOF,
+// or once scanAndWait closes it if they hold it open past WaitDelay.
+type ptyReader struct {
+	f *os.File
+}
+
+func (r ptyReader) Read(b []byte) (int, error) {
+	n, err := r.f.Read(b)
+	if errors.Is(err, syscall.EIO) || errors.Is(err, os.ErrClosed) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close does nothing: the terminal is closed when the process exits.
+func (r ptyReader) Close() error {
+	return nil
+}
+
+// ptyWriter writes input to the terminal. Closing it sends EOF (Ctrl-D),
+// which the terminal only passes on at the start of a line.
+type ptyWriter struct {
+	f       *os.File
+	midLine bool
+}
+
+func (w *ptyWriter) Write(b []byte) (int, error) {
+	n, err := w.f.Write(b)
+	if n > 0 {
+		w.midLine = b[n-1] != '\n'
+	}
+	return n, err
+}
+
+func (w *ptyWriter) Close() error {
+	eof := []byte{4}
+	if w.midLine {
+		// the first ends the line without a newline
+		eof = []byte{4, 4}
+	}
+	w.midLine = false
+	_, err := w.f.Write(eof)
+	return err
+}
@@ -0,0 +1,112 @@
+//go:build linux
+
+package p
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubprocess_PTY(t *testing.T) {
+	sp := newJob(t, "sh", "-c", `test -t 0 && test -t 1 && echo tty; echo err >&2; stty size`)
+	sp.PTY = true
+	sp.Capture = true
+	var stderrLines int
+	sp.Scanner = func(stderr bool, text string) {
+		if stderr {
+			stderrLines++
+		}
+	}
+	require.NoError(t, sp.SetWindowSize(40, 100))
+	require.NoError(t, sp.Run())
+
+	require.Equal(t, "tty\nerr\n40 100\n", sp.Stdout.String())
+	require.Zero(t, stderrLines)
+}
+
+func TestSubprocess_PTYInput(t *testing.T) {
+	sp := newJob(t, "sh", "-c", `read name; echo "hi $name"; cat`)
+	sp.PTY = true
+	sp.Capture = true
+	lines := make(chan string, 10)
+	sp.Scanner = func(stderr bool, text string) {
+		lines <- text
+	}
+	require.NoError(t, sp.Start())
+
+	_, err := sp.StdInWriter.Write([]byte("bob\n"))
+	require.NoError(t, err)
+	// the terminal echoes input
+	for _, want := range []string{"bob", "hi bob"} {
+		select {
+		case line := <-lines:
+			require.Equal(t, want, line)
+		case <-time.After(3 * time.Second):
+			t.Fatal("no reply")
+		}
+	}
+
+	// EOF ends cat, even mid-line
+	_, err = sp.StdInWriter.Write([]byte("tail"))
+	require.NoError(t, err)
+	require.NoError(t, sp.StdInWriter.Close())
+	require.NoError(t, sp.Wait())
+	require.Equal(t, "bob\nhi bob\ntailtail\n", sp.Stdout.String())
+}
+
+func TestSubprocess_PTYFeed(t *testing.T) {
+	sp := newJob(t, "wc", "-l")
+	sp.PTY = true
+	sp.Capture = true
+	// a Subprocess can run again with a fresh terminal
+	for range 2 {
+		sp.FeedString(strings.Repeat("line\n", 3))
+		require.NoError(t, sp.Run())
+		require.Equal(t, strings.Repeat("line\n", 3)+"3\n", sp.Stdout.String())
+	}
+}
+
+func TestSubprocess_PTYStop(t *testing.T) {
+	ready := make(chan struct{})
+	sp := newJob(t, "sh", "-c", `echo ready; sleep 30`)
+	sp.PTY = true
+	// sh -c catches SIGINT, losing it if it arrives before sleep has exec'd
+	sp.StopSignal = syscall.SIGTERM
+	sp.Scanner = func(stderr bool, text string) {
+		if text == "ready" {
+			close(ready)
+		}
+	}
+	require.NoError(t, sp.Start())
+	<-ready
+
+	start := time.Now()
+	require.NoError(t, sp.Stop())
+	require.Less(t, time.Since(start), 3*time.Second)
+	require.Error(t, sp.Wait())
+}
+
+func TestSubprocess_PTYWaitDelay(t *testing.T) {
+	// the background sleep holds the terminal open after sh exits
+	sp := newJob(t, "sh", "-c", `trap "" HUP; echo started; sleep 3 &`)
+	sp.PTY = true
+	sp.Capture = true
+	sp.WaitDelay = 100 * time.Millisecond
+	sp.GracePeriod = 100 * time.Millisecond
+
+	start := time.Now()
+	require.ErrorIs(t, sp.Run(), exec.ErrWaitDelay)
+	require.Less(t, time.Since(start), 2*time.Second)
+	require.Equal(t, "started\n", sp.Stdout.String())
+}
+
+func TestPipeline_PTY(t *testing.T) {
+	stages := newStages(t, []string{"echo", "x"}, []string{"cat"})
+	stages[0].PTY = true
+	require.ErrorContains(t, NewPipeline(stages...).Run(), "PTY")
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package p
+
+import (
+	"errors"
+	"os"
+)
+
+var errPTYUnsupported = errors.New("pty mode is only supported on linux")
+
+func setWinsize(f *os.File, rows, cols uint16) error {
+	return errPTYUnsupported
+}
+
+func (sp *Subprocess) setupPTY() error {
+	return errPTYUnsupported
+}
+
+func (sp *Subprocess) startedPTY(err error) {}
+
+func (sp *Subprocess) closePTY() {}
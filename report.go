@@ -0,0 +1,186 @@
+package p
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const reportedErrorEvent = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// ServiceContext identifies the service errors are reported for.
+type ServiceContext struct {
+	Service string `json:"service"`
+	Version string `json:"version,omitempty"`
+}
+
+// ErrorContext is the context of a reported error, as understood by Cloud Error Reporting.
+type ErrorContext struct {
+	HttpRequest    *HttpRequest    `json:"httpRequest,omitempty"`
+	User           string          `json:"user,omitempty"`
+	ReportLocation *ReportLocation `json:"reportLocation,omitempty"`
+}
+
+type ReportLocation struct {
+	FilePath     string `json:"filePath"`
+	LineNumber   int    `json:"lineNumber"`
+	FunctionName string `json:"functionName"`
+}
+
+// SetServiceContext sets the service name and version attached to reported
+// errors. If never set, K_SERVICE and K_REVISION (set by Cloud Run) are used.
+func (l *GcpLogger) SetServiceContext(service, version string) {
+	l.sink().service.Store(&ServiceContext{Service: service, Version: version})
+}
+
+func (l *GcpLogger) serviceContext() *ServiceContext {
+	if sc := l.sink().service.Load(); sc != nil {
+		return sc
+	}
+	return &ServiceContext{
+		Service: Coalesce(os.Getenv("K_SERVICE"), "unknown"),
+		Version: os.Getenv("K_REVISION"),
+	}
+}
+
+// ReportError logs err at Error severity with the stack of the caller, in
+// the format Cloud Error Reporting groups errors by. s and v, if given, are
+// formatted and prefixed to the error message.
+func (l *GcpLogger) ReportError(err error, s string, v ...any) {
+	if len(v) > 0 {
+		s = Format(s, v...)
+	}
+	l.report(Error, err, s, 3, false)
+}
+
+// ReportCritical is ReportError at Critical severity.
+func (l *GcpLogger) ReportCritical(err error, s string, v ...any) {
+	if len(v) > 0 {
+		s = Format(s, v...)
+	}
+	l.report(Critical, err, s, 3, false)
+}
+
+// skip is the number of frames above report to leave out of the stack.
+// If panicking, frames up to and including runtime.gopanic are left out too.
+func (l *GcpLogger) report(s Severity, err error, msg string, skip int, panicking bool) {
+	if !l.Enabled(s) {
+		return
+	}
+
+	switch {
+	case err == nil:
+	case msg == "":
+		msg = err.Error()
+	default:
+		msg = msg + ": " + err.Error()
+	}
+
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(skip, pcs)]
+	if panicking {
+		pcs = trimPanic(pcs)
+	}
+
+	var pc uintptr
+	if len(pcs) > 0 {
+		pc = pcs[0]
+	}
+
+	entry := l.entry(s, msg, pc)
+	entry.Type = reportedErrorEvent
+	entry.ServiceContext = l.serviceContext()
+	entry.StackTrace = formatStack(msg, pcs, panicking)
+	entry.ErrorContext = &ErrorContext{HttpRequest: l.httpReq}
+	if loc := entry.SourceLocation; loc != nil {
+		entry.ErrorContext.ReportLocation = &ReportLocation{
+			FilePath:     loc.File,
+			LineNumber:   loc.Line,
+			FunctionName: loc.Function,
+		}
+	}
+
+	l.write(entry)
+}
+
+func trimPanic(pcs []uintptr) []uintptr {
+	for i, pc := range pcs {
+		if fn := runtime.FuncForPC(pc - 1); fn != nil && fn.Name() == "runtime.gopanic" {
+			return pcs[i+1:]
+		}
+	}
+	return pcs
+}
+
+// formatStack renders msg and pcs like a Go panic, which Error Reporting
+// knows how to parse and groups errors by: the message, then a blank line if
+// panicking, then the stack in the format of runtime.Stack.
+func formatStack(msg string, pcs []uintptr, panicking bool) string {
+	var b strings.Builder
+	b.WriteString(msg + "\n")
+	if panicking {
+		b.WriteByte('\n')
+	}
+	b.WriteString(goroutineHeader())
+	b.WriteByte('\n')
+
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" {
+			b.WriteString(frame.Function + "(...)\n")
+			b.WriteString("\t" + frame.File + ":" + strconv.Itoa(frame.Line))
+			// inlined frames have no offset of their own
+			if frame.Func != nil && frame.PC >= frame.Entry {
+				b.WriteString(" +0x" + strconv.FormatUint(uint64(frame.PC-frame.Entry), 16))
+			}
+			b.WriteByte('\n')
+		}
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+// e.g. "goroutine 7 [running]:"
+func goroutineHeader() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		return string(buf[:i])
+	}
+	return "goroutine 1 [running]:"
+}
+
+// RecoverMiddleware recovers panics in next, reports them through the request's
+// logger (see FromContext and TraceMiddleware) and responds 500.
+// http.ErrAbortHandler is re-panicked, as net/http expects.
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			err, ok := rec.(error)
+			if !ok {
+				err = fmt.Errorf("%v", rec)
+			}
+
+			l := FromContext(r.Context()).WithHttpRequest(NewHttpRequest(r))
+			l.report(Critical, err, "panic", 2, true)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,90 @@
+package p
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGcpLogger_ReportError(t *testing.T) {
+	var out bytes.Buffer
+	l := NewGcpLogger(&out, &out)
+	l.SetServiceContext("push", "v1")
+
+	l.ReportError(errors.New("boom"), "append %d rows", 3)
+
+	got := decodeLines(t, &out)
+	require.Len(t, got, 1)
+
+	e := got[0]
+	require.Equal(t, "ERROR", e["severity"])
+	require.Equal(t, "append 3 rows: boom", e["message"])
+	require.Equal(t, reportedErrorEvent, e["@type"])
+	require.Equal(t, map[string]any{"service": "push", "version": "v1"}, e["serviceContext"])
+
+	stack := e["stack_trace"].(string)
+	require.Regexp(t, `^append 3 rows: boom\ngoroutine \d+ \[running\]:\n`, stack)
+	lines := strings.Split(stack, "\n")
+	require.Equal(t, "append 3 rows: boom", lines[0])
+	require.Contains(t, lines[2], "TestGcpLogger_ReportError")
+	require.Regexp(t, `^\t.*report_test\.go:\d+ \+0x[0-9a-f]+$`, lines[3])
+	require.NotContains(t, stack, "(*GcpLogger).report")
+
+	loc := e["context"].(map[string]any)["reportLocation"].(map[string]any)
+	require.Contains(t, loc["functionName"], "TestGcpLogger_ReportError")
+}
+
+func TestGcpLogger_ReportServiceContextFromEnv(t *testing.T) {
+	t.Setenv("K_SERVICE", "svc")
+	t.Setenv("K_REVISION", "svc-00001")
+
+	var out bytes.Buffer
+	NewGcpLogger(&out, &out).ReportCritical(errors.New("boom"), "")
+
+	e := decodeLines(t, &out)[0]
+	require.Equal(t, "CRITICAL", e["severity"])
+	require.Equal(t, "boom", e["message"])
+	require.Equal(t, map[string]any{"service": "svc", "version": "svc-00001"}, e["serviceContext"])
+}
+
+func panicky() {
+	panic("kaboom")
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	l := NewGcpLogger(&out, &out)
+
+	h := TraceMiddleware("proj", l)(RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panicky()
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	req.Header.Set("X-Cloud-Trace-Context", "abc/1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	e := decodeLines(t, &out)[0]
+	require.Equal(t, "panic: kaboom", e["message"])
+	require.Equal(t, "projects/proj/traces/abc", e["logging.googleapis.com/trace"])
+	lines := strings.Split(e["stack_trace"].(string), "\n")
+	require.Equal(t, []string{"panic: kaboom", ""}, lines[:2])
+	require.Regexp(t, `^goroutine \d+ \[running\]:$`, lines[2])
+	require.Contains(t, lines[3], "panicky")
+
+	errCtx := e["context"].(map[string]any)
+	require.Equal(t, "/boom", errCtx["httpRequest"].(map[string]any)["requestUrl"])
+
+	require.Panics(t, func() {
+		RecoverMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic(http.ErrAbortHandler)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+	})
+}
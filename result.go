@@ -0,0 +1,113 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Result describes how a Subprocess run ended.
+type Result struct {
+	Command string
+	// ExitCode is -1 if the process was killed by a signal.
+	ExitCode int
+	// Signal is the signal that killed the process, if any.
+	Signal os.Signal
+	// TimedOut and Cancelled report whether Context was done because its
+	// deadline passed or it was cancelled, and the process was stopped for it.
+	TimedOut, Cancelled bool
+
+	Wall, User, System time.Duration
+	// MaxRSS is the peak resident set size in bytes, where the OS reports it.
+	MaxRSS int64
+	// StdinErr is the error reading Stdin, if any.
+	StdinErr error
+	// StderrTail is the end of stderr (the last 4KB), whether or not it was captured.
+	StderrTail string
+}
+
+func (r *Result) Success() bool {
+	return r.ExitCode == 0 && r.Signal == nil
+}
+
+// ExitError is returned by Subprocess.Wait for a process that failed.
+// Err is the underlying error, usually an *exec.ExitError, whose text
+// (e.g. "exit status 3") the ExitError keeps.
+type ExitError struct {
+	*Result
+	Err error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+const stderrTailSize = 4 << 10
+
+// Result returns the outcome of the last run, once Wait has returned.
+func (sp *Subprocess) Result() *Result {
+	return sp.result
+}
+
+func (sp *Subprocess) newResult(started time.Time) *Result {
+	res := &Result{
+		Command:    sp.String(),
+		ExitCode:   -1,
+		Wall:       time.Since(started),
+		StderrTail: sp.stderrTail.String(),
+	}
+
+	ps := sp.Cmd.ProcessState
+	if ps == nil {
+		return res
+	}
+	res.ExitCode = ps.ExitCode()
+	res.User, res.System = ps.UserTime(), ps.SystemTime()
+	res.MaxRSS = maxRSS(ps)
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		res.Signal = ws.Signal()
+	}
+
+	if !ps.Success() {
+		switch err := sp.Context.Err(); {
+		case errors.Is(err, context.DeadlineExceeded):
+			res.TimedOut = true
+		case errors.Is(err, context.Canceled):
+			res.Cancelled = true
+		}
+	}
+	return res
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(b []byte) (int, error) {
+	n := len(b)
+	if len(b) >= t.max {
+		t.buf = append(t.buf[:0], b[len(b)-t.max:]...)
+		return n, nil
+	}
+	if over := len(t.buf) + len(b) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	t.buf = append(t.buf, b...)
+	return n, nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.buf)
+}
+
+func (t *tailBuffer) Reset() {
+	t.buf = t.buf[:0]
+}
@@ -0,0 +1,65 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubprocess_Result(t *testing.T) {
+	sp := newJob(t, "sh", "-c", "echo err1 >&2; echo err2 >&2; exit 3")
+	err := sp.Run()
+
+	var ee *ExitError
+	require.True(t, errors.As(err, &ee), err)
+	require.Equal(t, 3, ee.ExitCode)
+	require.Nil(t, ee.Signal)
+	require.False(t, ee.TimedOut || ee.Cancelled)
+	require.Equal(t, "err1\nerr2\n", ee.StderrTail)
+	require.Equal(t, "exit status 3", err.Error())
+
+	var xe *exec.ExitError
+	require.True(t, errors.As(err, &xe))
+
+	sp = newJob(t, "true")
+	require.NoError(t, sp.Run())
+	res := sp.Result()
+	require.True(t, res.Success())
+	require.Positive(t, res.Wall)
+	if runtime.GOOS == "linux" {
+		require.Positive(t, res.MaxRSS)
+	}
+}
+
+func TestSubprocess_ResultTimedOut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	sp := newJob(t, "sleep", "5")
+	sp.Context = ctx
+	err := sp.Run()
+
+	var ee *ExitError
+	require.True(t, errors.As(err, &ee), err)
+	require.True(t, ee.TimedOut)
+	require.False(t, ee.Cancelled)
+	require.NotNil(t, ee.Signal)
+	require.Equal(t, -1, ee.ExitCode)
+}
+
+func TestTailBuffer(t *testing.T) {
+	tb := tailBuffer{max: 8}
+	tb.Write([]byte("abc"))
+	tb.Write([]byte("defg"))
+	require.Equal(t, "abcdefg", tb.String())
+	tb.Write([]byte("hij"))
+	require.Equal(t, "cdefghij", tb.String())
+	tb.Write([]byte(strings.Repeat("x", 10) + "yz"))
+	require.Equal(t, "xxxxxxyz", tb.String())
+}
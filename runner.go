@@ -0,0 +1,197 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrSkipped is the error of jobs a fail-fast Runner never started.
+var ErrSkipped = errors.New("skipped")
+
+// Job is a Subprocess run by a Runner, which runs a copy of it (see
+// JobResult.Subprocess). A zero Timeout or Retries uses the Runner's; set
+// them to NoTimeout or NoRetries to turn the Runner's off for the job.
+type Job struct {
+	Name       string
+	Subprocess *Subprocess
+	Timeout    time.Duration
+	Retries    int
+}
+
+const (
+	NoTimeout time.Duration = -1
+	NoRetries               = -1
+)
+
+type RunnerConfig struct {
+	// Concurrency is the number of jobs run at once, runtime.NumCPU() by default.
+	Concurrency int
+	// Timeout limits each attempt of a job. Zero means no limit.
+	Timeout time.Duration
+	// Retries is the number of times a failed job is run again, waiting RetryDelay in between.
+	Retries    int
+	RetryDelay time.Duration
+	// FailFast stops every other job after the first failure.
+	FailFast bool
+	// Print prints the output of every job, each line prefixed by a label
+	// with the job's name (see Label) so interleaved output can be told apart.
+	Print bool
+}
+
+type JobResult struct {
+	Name string
+	// Subprocess is the copy of the job's Subprocess that was run, holding
+	// the output of its last attempt. It is nil if the job never ran.
+	Subprocess *Subprocess
+	Attempts   int
+	Duration   time.Duration
+	// ExitCode is -1 if the job was killed or never ran.
+	ExitCode int
+	Err      error
+}
+
+// Runner runs jobs with bounded concurrency.
+type Runner struct {
+	cfg  RunnerConfig
+	jobs []Job
+}
+
+func NewRunner(cfg RunnerConfig) *Runner {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = runtime.NumCPU()
+	}
+	return &Runner{cfg: cfg}
+}
+
+// Add adds a job named name (sp.Bin if empty).
+func (r *Runner) Add(name string, sp *Subprocess) *Runner {
+	return r.AddJob(Job{Name: name, Subprocess: sp})
+}
+
+func (r *Runner) AddJob(j Job) *Runner {
+	j.Name = Coalesce(j.Name, j.Subprocess.Bin)
+	r.jobs = append(r.jobs, j)
+	return r
+}
+
+var labelColors = []int{36, 33, 35, 32, 34, 96, 93, 95}
+
+// Run runs every job and returns their results in the order they were added,
+// with an error joining the failures (just the first with FailFast).
+func (r *Runner) Run(ctx context.Context) ([]JobResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	width := 0
+	for _, j := range r.jobs {
+		width = max(width, len(j.Name))
+	}
+
+	results := make([]JobResult, len(r.jobs))
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, r.cfg.Concurrency)
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i, j := range r.jobs {
+		results[i] = JobResult{Name: j.Name, ExitCode: -1, Err: ErrSkipped}
+		sp := j.Subprocess.clone()
+		if r.cfg.Print {
+			sp.Print = true
+			if sp.Label.Value == "" {
+				sp.Label = Label{Value: j.Name, Len: width, Color: labelColors[i%len(labelColors)]}
+			}
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res := r.run(ctx, j, sp)
+			results[i] = res
+			if res.Err != nil && r.cfg.FailFast {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", res.Name, res.Err)
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if r.cfg.FailFast {
+		return results, firstErr
+	}
+	var errs []error
+	for _, res := range results {
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", res.Name, res.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+func (r *Runner) run(ctx context.Context, j Job, sp *Subprocess) (res JobResult) {
+	res = JobResult{Name: j.Name, Subprocess: sp, ExitCode: -1}
+	timeout := j.Timeout
+	if timeout == 0 {
+		timeout = r.cfg.Timeout
+	}
+	retries := j.Retries
+	if retries == 0 {
+		retries = r.cfg.Retries
+	}
+
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+
+	for {
+		res.Attempts++
+		res.Err = r.attempt(ctx, sp, timeout)
+		if ps := sp.Cmd; ps != nil && ps.ProcessState != nil {
+			res.ExitCode = ps.ProcessState.ExitCode()
+		}
+		if res.Err == nil || res.Attempts > retries || ctx.Err() != nil {
+			return res
+		}
+
+		select {
+		case <-time.After(r.cfg.RetryDelay):
+		case <-ctx.Done():
+			return res
+		}
+	}
+}
+
+func (r *Runner) attempt(ctx context.Context, sp *Subprocess, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	sp.Context = ctx
+	if err := sp.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s: %w", timeout, err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package p
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newJob(t *testing.T, bin string, args ...string) *Subprocess {
+	t.Helper()
+	sp, err := NewSubprocess(bin, args...)
+	require.NoError(t, err)
+	return sp
+}
+
+func TestRunner_Concurrency(t *testing.T) {
+	r := NewRunner(RunnerConfig{Concurrency: 2, Print: true})
+	for range 4 {
+		r.Add("", newJob(t, "sleep", "0.3"))
+	}
+	r.Add("done", newJob(t, "true"))
+
+	start := time.Now()
+	results, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 600*time.Millisecond)
+
+	require.Len(t, results, 5)
+	require.Equal(t, "sleep", results[0].Name)
+	require.Equal(t, 0, results[4].ExitCode)
+	require.Equal(t, Label{Value: "done", Len: 5, Color: labelColors[4]}, results[4].Subprocess.Label)
+	// the jobs' own Subprocesses are left alone
+	require.False(t, r.jobs[4].Subprocess.Print)
+	require.Nil(t, r.jobs[4].Subprocess.Cmd)
+}
+
+func TestRunner_RetryAndTimeout(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	flaky := newJob(t, "sh", "-c", "test -f "+marker+" || { touch "+marker+"; exit 1; }")
+
+	r := NewRunner(RunnerConfig{Retries: 1, RetryDelay: 10 * time.Millisecond})
+	r.Add("flaky", flaky)
+	r.AddJob(Job{Name: "slow", Subprocess: newJob(t, "sleep", "5"), Timeout: 100 * time.Millisecond, Retries: NoRetries})
+
+	results, err := r.Run(context.Background())
+	require.ErrorContains(t, err, "slow: timed out after 100ms")
+	require.NotContains(t, err.Error(), "flaky")
+
+	require.Equal(t, 2, results[0].Attempts)
+	require.Positive(t, results[0].Duration)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, 1, results[1].Attempts)
+	require.Equal(t, -1, results[1].ExitCode)
+}
+
+func TestRunner_FailFast(t *testing.T) {
+	r := NewRunner(RunnerConfig{Concurrency: 2, FailFast: true})
+	r.Add("fails", newJob(t, "sh", "-c", "sleep 0.1; exit 1"))
+	r.Add("slow", newJob(t, "sleep", "5"))
+	r.Add("later", newJob(t, "sleep", "5"))
+
+	start := time.Now()
+	results, err := r.Run(context.Background())
+	require.ErrorContains(t, err, "fails: exit status 1")
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Error(t, results[1].Err)
+	require.Error(t, results[2].Err)
+}
+
+func TestRunner_RetryStdin(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	sp := newJob(t, "sh", "-c", "read x; echo $x; test -f "+marker+" || { touch "+marker+"; exit 1; }")
+	sp.Capture = true
+	sp.FeedString("hi\n")
+
+	r := NewRunner(RunnerConfig{Retries: 1})
+	r.Add("flaky", sp)
+	results, err := r.Run(context.Background())
+	require.NoError(t, err)
+
+	// every attempt gets the input
+	require.Equal(t, 2, results[0].Attempts)
+	require.Equal(t, "hi\n", results[0].Subprocess.Stdout.String())
+}
@@ -0,0 +1,227 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session runs commands with shared aliases, environment and working directory,
+// keeping a history of what it ran.
+type Session struct {
+	// Subprocess, if set, is the template commands are configured from:
+	// they get a copy of all of its options but Bin and Args, with its Env
+	// overlaid on the session's and its WorkingDir, if set, replacing it.
+	Subprocess *Subprocess
+	Alias      map[string]string
+	Env        map[string]string
+	WorkingDir string
+	mu         sync.Mutex
+	history    []HistoryEntry
+	running    map[*Subprocess]int
+}
+
+// HistoryEntry records a command run by a Session. ExitCode is -1 while it
+// runs, or if it was killed by a signal.
+type HistoryEntry struct {
+	Command  string
+	Dir      string
+	Start    time.Time
+	Duration time.Duration
+	ExitCode int
+	Err      error
+}
+
+func NewSession() *Session {
+	return &Session{
+		Alias: make(map[string]string),
+		Env:   make(map[string]string),
+	}
+}
+
+func (s *Session) AddAlias(k, v string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Alias[k] = v
+}
+
+func (s *Session) SetEnv(k, v string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Env == nil {
+		s.Env = make(map[string]string)
+	}
+	s.Env[k] = v
+}
+
+// Command returns a Subprocess for name, which may be an alias, configured
+// from the session's template. An alias expands to its whitespace-separated
+// words, split like a shell without expansion, e.g. AddAlias("ll", "ls -l") makes Command("ll", "/tmp") run
+// "ls -l /tmp". The environment is os.Environ() overlaid by the session's
+// Env, then the template's.
+func (s *Session) Command(name string, args ...string) (*Subprocess, error) {
+	s.mu.Lock()
+	alias, ok := s.Alias[name]
+	env := mergeEnv(os.Environ(), s.Env)
+	dir := s.WorkingDir
+	s.mu.Unlock()
+
+	if ok {
+		fields, err := splitArgs(alias)
+		if err != nil {
+			return nil, fmt.Errorf("alias '%s': %w", name, err)
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("alias '%s' is empty", name)
+		}
+		name, args = fields[0], append(fields[1:], args...)
+	}
+
+	sp, err := NewSubprocess(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	if t := s.Subprocess; t != nil {
+		c := t.clone()
+		c.Bin, c.Args = sp.Bin, sp.Args
+		sp = c
+		dir = Coalesce(t.WorkingDir, dir)
+		maps.Copy(env, t.Env)
+	}
+	sp.Env = env
+	sp.WorkingDir = dir
+	return sp, nil
+}
+
+// splitArgs splits s into words on whitespace, honouring single and double
+// quotes and backslash escapes like a shell, but without any expansion.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args   []string
+		word   strings.Builder
+		inWord bool
+		quote  rune
+		escape bool
+	)
+	for _, r := range s {
+		switch {
+		case escape:
+			word.WriteRune(r)
+			escape = false
+		case r == '\\' && quote != '\'':
+			escape, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escape {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// mergeEnv parses environ ("key=value" pairs) into a map and overlays it with overrides.
+func mergeEnv(environ []string, overrides ...map[string]string) map[string]string {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok && k != "" {
+			env[k] = v
+		}
+	}
+	for _, m := range overrides {
+		for k, v := range m {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// Start starts name (see Command) under ctx, if not nil, and adds it to the
+// history. Finish it with Session.Wait so its exit code is recorded.
+func (s *Session) Start(ctx context.Context, name string, args ...string) (*Subprocess, error) {
+	sp, err := s.Command(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	if ctx != nil {
+		sp.Context = ctx
+	}
+
+	entry := HistoryEntry{Command: sp.String(), Dir: sp.WorkingDir, Start: time.Now(), ExitCode: -1}
+	err = sp.Start()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		entry.Err = err
+		s.history = append(s.history, entry)
+		return nil, err
+	}
+	if s.running == nil {
+		s.running = make(map[*Subprocess]int)
+	}
+	s.running[sp] = len(s.history)
+	s.history = append(s.history, entry)
+	return sp, nil
+}
+
+// Wait waits for sp, started by s.Start, and records its outcome in the history.
+func (s *Session) Wait(sp *Subprocess) error {
+	err := sp.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.running[sp]
+	if !ok {
+		return err
+	}
+	delete(s.running, sp)
+
+	entry := &s.history[i]
+	entry.Duration = time.Since(entry.Start)
+	entry.Err = err
+	if sp.Cmd != nil && sp.Cmd.ProcessState != nil {
+		entry.ExitCode = sp.Cmd.ProcessState.ExitCode()
+	}
+	return err
+}
+
+// Run starts name and waits for it. The Subprocess is returned even if it
+// fails, for its captured output.
+func (s *Session) Run(ctx context.Context, name string, args ...string) (*Subprocess, error) {
+	sp, err := s.Start(ctx, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sp, s.Wait(sp)
+}
+
+// History returns the commands started so far, oldest first.
+func (s *Session) History() []HistoryEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]HistoryEntry(nil), s.history...)
+}
@@ -0,0 +1,73 @@
+package p
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_Run(t *testing.T) {
+	t.Setenv("SESSION_BASE", "from-os")
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "marker"), nil, 0o600))
+
+	s := NewSession()
+	s.Subprocess = &Subprocess{Capture: true, Env: map[string]string{"SESSION_TMPL": "from-template"}}
+	s.WorkingDir = dir
+	s.SetEnv("SESSION_OWN", "from-session")
+	s.AddAlias("show", `sh -c "echo $SESSION_BASE $SESSION_OWN $SESSION_TMPL; ls"`)
+	s.AddAlias("fail", "sh -c 'exit 3'")
+
+	sp, err := s.Run(context.Background(), "show")
+	require.NoError(t, err)
+	require.Equal(t, "from-os from-session from-template\nmarker\n", sp.Stdout.String())
+
+	_, err = s.Run(context.Background(), "fail")
+	require.Error(t, err)
+
+	h := s.History()
+	require.Len(t, h, 2)
+	require.Equal(t, 0, h[0].ExitCode)
+	require.Equal(t, dir, h[0].Dir)
+	require.Positive(t, h[0].Duration)
+	require.Equal(t, "sh -c exit 3", h[1].Command)
+	require.Equal(t, 3, h[1].ExitCode)
+	require.Error(t, h[1].Err)
+}
+
+func TestSession_CommandTemplate(t *testing.T) {
+	s := NewSession()
+	s.Subprocess = &Subprocess{
+		Bin:         "ignored",
+		StopSignal:  syscall.SIGTERM,
+		GracePeriod: time.Second,
+		Split:       SplitNUL,
+		PTY:         true,
+		Stdin:       strings.NewReader("in"),
+	}
+	sp, err := s.Command("cat", "-")
+	require.NoError(t, err)
+	require.Equal(t, "cat", sp.Bin)
+	require.Equal(t, []string{"-"}, sp.Args)
+	require.Equal(t, syscall.SIGTERM, sp.StopSignal)
+	require.Equal(t, time.Second, sp.GracePeriod)
+	require.Equal(t, SplitNUL, sp.Split)
+	require.True(t, sp.PTY)
+	require.Equal(t, s.Subprocess.Stdin, sp.Stdin)
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`gsutil  cp -r "a b" 'c "d"' e\ f ""`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"gsutil", "cp", "-r", "a b", `c "d"`, "e f", ""}, args)
+
+	_, err = splitArgs(`echo "open`)
+	require.Error(t, err)
+}
@@ -0,0 +1,170 @@
+package p
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+)
+
+// SlogHandler is a slog.Handler that writes entries in the same format as GcpLogger.
+type SlogHandler struct {
+	l      *GcpLogger
+	opts   slog.HandlerOptions
+	fields map[string]any
+	groups []string
+}
+
+// NewSlogHandler returns a handler writing through l. A nil l writes to stdout/stderr.
+// If opts is nil, records at slog.LevelInfo and above are handled. The source
+// location is only logged if opts.AddSource is set.
+func NewSlogHandler(l *GcpLogger, opts *slog.HandlerOptions) *SlogHandler {
+	if l == nil {
+		l = &GcpLogger{}
+	}
+	h := &SlogHandler{l: l}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// SeverityFromLevel maps slog levels onto Severity. Levels in between the
+// slog constants map to the less severe of the two, e.g. Info+2 is Notice.
+func SeverityFromLevel(lvl slog.Level) Severity {
+	switch {
+	case lvl < slog.LevelInfo:
+		return Debug
+	case lvl < slog.LevelInfo+2:
+		return Info
+	case lvl < slog.LevelWarn:
+		return Notice
+	case lvl < slog.LevelError:
+		return Warning
+	case lvl < slog.LevelError+4:
+		return Error
+	case lvl < slog.LevelError+8:
+		return Critical
+	case lvl < slog.LevelError+12:
+		return Alert
+	default:
+		return Emergency
+	}
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	minLvl := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLvl = h.opts.Level.Level()
+	}
+	return lvl >= minLvl && h.l.Enabled(SeverityFromLevel(lvl))
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := cloneFields(h.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = h.addAttr(fields, h.groups, a)
+		return true
+	})
+
+	var pc uintptr
+	if h.opts.AddSource {
+		pc = r.PC
+	}
+	entry := h.l.entry(SeverityFromLevel(r.Level), r.Message, pc)
+	// a zero time is left out
+	entry.Time = r.Time
+	// correlate with the request if TraceMiddleware put a logger in ctx
+	if cl, ok := loggerFromContext(ctx); ok && entry.Trace == nil {
+		entry.Trace, entry.SpanID, entry.TraceSampled = cl.trace, cl.spanID, cl.sampled
+	}
+	if len(h.l.fields) > 0 {
+		merged := maps.Clone(h.l.fields)
+		maps.Copy(merged, fields)
+		fields = merged
+	}
+	entry.Fields = fields
+
+	h.l.write(entry)
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	c := *h
+	c.fields = cloneFields(h.fields)
+	for _, a := range attrs {
+		c.fields = c.addAttr(c.fields, c.groups, a)
+	}
+	return &c
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.groups = append(slices.Clip(h.groups), name)
+	return &c
+}
+
+// addAttr inserts a into fields under the group path, creating groups as needed.
+func (h *SlogHandler) addAttr(fields map[string]any, groups []string, a slog.Attr) map[string]any {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() != slog.KindGroup && h.opts.ReplaceAttr != nil {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	if fields == nil {
+		fields = make(map[string]any)
+	}
+	dst := fields
+	for _, g := range groups {
+		sub, ok := dst[g].(map[string]any)
+		if !ok {
+			sub = make(map[string]any)
+			dst[g] = sub
+		}
+		dst = sub
+	}
+
+	if a.Value.Kind() != slog.KindGroup {
+		// nested fields aren't converted when the entry is marshalled
+		dst[a.Key] = fieldValue(a.Value.Any())
+		return fields
+	}
+
+	attrs := a.Value.Group()
+	if len(attrs) == 0 {
+		return fields
+	}
+	// groups with an empty key are inlined
+	path := slices.Clone(groups)
+	if a.Key != "" {
+		path = append(path, a.Key)
+	}
+	for _, ga := range attrs {
+		fields = h.addAttr(fields, path, ga)
+	}
+	return fields
+}
+
+func cloneFields(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]any, len(m))
+	for k, v := range m {
+		if sub, ok := v.(map[string]any); ok {
+			v = cloneFields(sub)
+		}
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,94 @@
+package p
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/slogtest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeverityFromLevel(t *testing.T) {
+	require.Equal(t, Debug, SeverityFromLevel(slog.LevelDebug))
+	require.Equal(t, Info, SeverityFromLevel(slog.LevelInfo))
+	require.Equal(t, Notice, SeverityFromLevel(slog.LevelInfo+2))
+	require.Equal(t, Warning, SeverityFromLevel(slog.LevelWarn))
+	require.Equal(t, Error, SeverityFromLevel(slog.LevelError))
+	require.Equal(t, Critical, SeverityFromLevel(slog.LevelError+4))
+	require.Equal(t, Alert, SeverityFromLevel(slog.LevelError+8))
+	require.Equal(t, Emergency, SeverityFromLevel(slog.LevelError+100))
+}
+
+func TestSlogHandler(t *testing.T) {
+	var out bytes.Buffer
+	l := NewGcpLogger(&out, &out).With("service", "push")
+	logger := slog.New(NewSlogHandler(l, &slog.HandlerOptions{Level: slog.LevelDebug, AddSource: true}))
+
+	logger.Debug("debug", "n", 1)
+	logger.With("a", "b").WithGroup("req").With("id", 7).
+		Warn("slow", "ms", 250, slog.Group("db", "table", "t"), slog.Group("empty"))
+	logger.WithGroup("unused").Error("no attrs")
+	logger.Info("nested", slog.Group("g", slog.Any("err", errors.New("boom")), slog.Any("user", secretUser("bob"))))
+
+	got := decodeLines(t, &out)
+	require.Len(t, got, 4)
+
+	require.Equal(t, "DEBUG", got[0]["severity"])
+	require.Equal(t, float64(1), got[0]["n"])
+	require.Equal(t, "push", got[0]["service"])
+
+	require.Equal(t, "WARNING", got[1]["severity"])
+	require.Equal(t, "slow", got[1]["message"])
+	require.Equal(t, "b", got[1]["a"])
+	require.Equal(t, map[string]any{
+		"id": float64(7),
+		"ms": float64(250),
+		"db": map[string]any{"table": "t"},
+	}, got[1]["req"])
+
+	require.Equal(t, "ERROR", got[2]["severity"])
+	require.NotContains(t, got[2], "unused")
+
+	require.Equal(t, map[string]any{"err": "boom", "user": "b***"}, got[3]["g"])
+
+	loc := got[0]["logging.googleapis.com/sourceLocation"].(map[string]any)
+	require.True(t, strings.HasSuffix(loc["file"].(string), "slog_test.go"), loc["file"])
+}
+
+type secretUser string
+
+func (u secretUser) LogValue() slog.Value {
+	return slog.StringValue(string(u[:1]) + "***")
+}
+
+func TestSlogHandler_Enabled(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(NewSlogHandler(NewGcpLogger(&out, &out), nil))
+
+	logger.Debug("hidden")
+	logger.Info("shown")
+
+	got := decodeLines(t, &out)
+	require.Len(t, got, 1)
+	require.Equal(t, "shown", got[0]["message"])
+	require.NotContains(t, got[0], "logging.googleapis.com/sourceLocation")
+}
+
+func TestSlogHandler_Conformance(t *testing.T) {
+	var out bytes.Buffer
+	h := NewSlogHandler(NewGcpLogger(&out, &out), nil)
+
+	err := slogtest.TestHandler(h, func() []map[string]any {
+		got := decodeLines(t, &out)
+		for _, m := range got {
+			m[slog.MessageKey], m[slog.LevelKey] = m["message"], m["severity"]
+			delete(m, "message")
+			delete(m, "severity")
+		}
+		return got
+	})
+	require.NoError(t, err)
+}
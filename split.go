@@ -0,0 +1,136 @@
+package p
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// SplitMode is how a Subprocess splits its output into tokens.
+type SplitMode int
+
+const (
+	// SplitLines splits on newlines, which are stripped (and added back when capturing).
+	SplitLines SplitMode = iota
+	// SplitChunks passes output on as it is read, without splitting.
+	SplitChunks
+	// SplitNUL splits on NUL bytes, as written by e.g. find -print0.
+	SplitNUL
+	// SplitJSON splits a stream of JSON objects or arrays, pretty-printed or not.
+	SplitJSON
+)
+
+const defaultMaxTokenSize = 1 << 20
+
+func (sp *Subprocess) newScanner(r io.Reader) *bufio.Scanner {
+	limit := sp.MaxTokenSize
+	if limit <= 0 {
+		limit = defaultMaxTokenSize
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, min(bufio.MaxScanTokenSize, limit)), limit)
+	sc.Split(sp.splitFunc())
+	return sc
+}
+
+func (sp *Subprocess) splitFunc() bufio.SplitFunc {
+	if sp.SplitFunc != nil {
+		return sp.SplitFunc
+	}
+	switch sp.Split {
+	case SplitChunks:
+		return scanChunks
+	case SplitNUL:
+		return scanNUL
+	case SplitJSON:
+		return scanJSON
+	}
+	return bufio.ScanLines
+}
+
+// terminator is appended to tokens when capturing them.
+func (sp *Subprocess) terminator() string {
+	if sp.SplitFunc != nil {
+		return "\n"
+	}
+	switch sp.Split {
+	case SplitChunks:
+		return ""
+	case SplitNUL:
+		return "\x00"
+	}
+	return "\n"
+}
+
+func scanChunks(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	return len(data), data, nil
+}
+
+func scanNUL(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// scanJSON returns each top-level JSON object or array. Anything else is
+// returned a line at a time, so stray log lines aren't lost.
+func scanJSON(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for start < len(data) && isJSONSpace(data[start]) {
+		start++
+	}
+	if start == len(data) {
+		return start, nil, nil
+	}
+
+	if c := data[start]; c != '{' && c != '[' {
+		if i := bytes.IndexByte(data[start:], '\n'); i >= 0 {
+			return start + i + 1, bytes.TrimSpace(data[start : start+i]), nil
+		}
+		if atEOF {
+			return len(data), bytes.TrimSpace(data[start:]), nil
+		}
+		return start, nil, nil
+	}
+
+	depth, inString, escaped := 0, false, false
+	for i := start; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth--; depth == 0 {
+				return i + 1, data[start : i+1], nil
+			}
+		}
+	}
+
+	if atEOF {
+		return 0, nil, errors.New("unterminated JSON value")
+	}
+	return start, nil, nil
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,68 @@
+package p
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func scanTokens(t *testing.T, sp *Subprocess, args ...string) []string {
+	t.Helper()
+
+	var tokens []string
+	sp.Scanner = func(stderr bool, text string) {
+		tokens = append(tokens, text)
+	}
+	require.NoError(t, sp.Run(args...))
+	return tokens
+}
+
+func TestSubprocess_Split(t *testing.T) {
+	sp := newJob(t, "printf", "")
+	sp.Capture = true
+	sp.Split = SplitNUL
+	require.Equal(t, []string{"a b", "c"}, scanTokens(t, sp, `a b\0c\0`))
+	require.Equal(t, "a b\x00c\x00", sp.Stdout.String())
+
+	sp.Split = SplitJSON
+	json := `{"a": {"b": "}"}}\n[1,\n 2]\nnot json\n{"c": "\\"{"}`
+	require.Equal(t, []string{`{"a": {"b": "}"}}`, "[1,\n 2]", "not json", `{"c": "\"{"}`}, scanTokens(t, sp, json))
+
+	sp.Split = SplitChunks
+	require.Equal(t, "abc", strings.Join(scanTokens(t, sp, "abc"), ""))
+	require.Equal(t, "abc", sp.Stdout.String())
+
+	sp.Split = SplitLines
+	sp.SplitFunc = scanNUL
+	require.Equal(t, []string{"x", "y"}, scanTokens(t, sp, `x\0y`))
+}
+
+func TestSubprocess_LongLines(t *testing.T) {
+	sp := newJob(t, "sh", "-c", `head -c 2000 /dev/zero | tr '\0' x; echo; echo after; head -c 1000000 /dev/zero`)
+	sp.Capture = true
+	sp.MaxTokenSize = 1000
+
+	err := sp.Run()
+	require.ErrorContains(t, err, "stdout: bufio.Scanner: token too long")
+	require.Empty(t, sp.Stdout.String())
+
+	sp.MaxTokenSize = 0
+	require.NoError(t, sp.Run())
+	require.True(t, strings.HasPrefix(sp.Stdout.String(), strings.Repeat("x", 2000)+"\nafter\n"))
+}
+
+func TestSubprocess_RawWriters(t *testing.T) {
+	var out, errOut bytes.Buffer
+	sp := newJob(t, "sh", "-c", "printf 'no newline'; echo oops >&2; exit 1")
+	sp.Capture = true
+	sp.StdoutWriter = &out
+	sp.StderrWriter = &errOut
+
+	require.Error(t, sp.Run())
+	require.Equal(t, "no newline", out.String())
+	require.Equal(t, "oops\n", errOut.String())
+	require.Empty(t, sp.Stdout.String())
+	require.Equal(t, "oops\n", sp.Result().StderrTail)
+}
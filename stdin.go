@@ -0,0 +1,152 @@
+package p
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FeedString sets Stdin to s.
+func (sp *Subprocess) FeedString(s string) {
+	sp.Stdin = &stringFeed{s: s}
+}
+
+// FeedFile sets Stdin to the contents of the file at path, which is opened
+// when the process starts reading and closed when it exits.
+func (sp *Subprocess) FeedFile(path string) {
+	sp.Stdin = &fileFeed{path: path}
+}
+
+// FeedFunc sets Stdin to whatever fn writes, e.g. rows written with csv.Writer:
+//
+//	sp.FeedFunc(func(w io.Writer) error {
+//		cw := csv.NewWriter(w)
+//		cw.WriteAll(rows)
+//		return cw.Error()
+//	})
+//
+// fn runs in its own goroutine once the process starts reading. If the
+// process exits first, fn's writes fail.
+func (sp *Subprocess) FeedFunc(fn func(io.Writer) error) {
+	sp.Stdin = &funcFeed{fn: fn}
+}
+
+// feeds are reset when the process exits, so a Subprocess can be run again.
+type feed interface {
+	reset()
+}
+
+// feeds may be reset while a Read is still in progress, if the process
+// exits without reading everything (see Subprocess.WaitDelay).
+
+type stringFeed struct {
+	s string
+
+	mu sync.Mutex
+	r  *strings.Reader
+}
+
+func (sf *stringFeed) Read(b []byte) (int, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.r == nil {
+		sf.r = strings.NewReader(sf.s)
+	}
+	return sf.r.Read(b)
+}
+
+func (sf *stringFeed) reset() {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.r = nil
+}
+
+type fileFeed struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (ff *fileFeed) Read(b []byte) (int, error) {
+	ff.mu.Lock()
+	if ff.f == nil {
+		f, err := os.Open(ff.path)
+		if err != nil {
+			ff.mu.Unlock()
+			return 0, err
+		}
+		ff.f = f
+	}
+	f := ff.f
+	ff.mu.Unlock()
+
+	return f.Read(b)
+}
+
+func (ff *fileFeed) reset() {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	if ff.f != nil {
+		ff.f.Close()
+		ff.f = nil
+	}
+}
+
+type funcFeed struct {
+	fn func(io.Writer) error
+
+	mu sync.Mutex
+	pr *io.PipeReader
+}
+
+func (ff *funcFeed) Read(b []byte) (int, error) {
+	ff.mu.Lock()
+	if ff.pr == nil {
+		pr, pw := io.Pipe()
+		ff.pr = pr
+		go func() {
+			pw.CloseWithError(ff.fn(pw))
+		}()
+	}
+	pr := ff.pr
+	ff.mu.Unlock()
+
+	return pr.Read(b)
+}
+
+func (ff *funcFeed) reset() {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	if ff.pr != nil {
+		ff.pr.Close()
+		ff.pr = nil
+	}
+}
+
+// stdinReader records the error reading Stdin, so Wait can report it.
+type stdinReader struct {
+	r io.Reader
+
+	mu  sync.Mutex
+	err error
+}
+
+func (s *stdinReader) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	if err != nil && err != io.EOF {
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+	}
+	return n, err
+}
+
+func (s *stdinReader) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
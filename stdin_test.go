@@ -0,0 +1,103 @@
+package p
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubprocess_Stdin(t *testing.T) {
+	sp := newJob(t, "wc", "-l")
+	sp.Capture = true
+	sp.FeedString("a\nb\nc\n")
+	require.NoError(t, sp.Run())
+	require.Nil(t, sp.StdInWriter)
+	require.Equal(t, "3", strings.TrimSpace(sp.Stdout.String()))
+	require.NoError(t, sp.Run())
+	require.Equal(t, "3", strings.TrimSpace(sp.Stdout.String()))
+
+	path := filepath.Join(t.TempDir(), "in.txt")
+	require.NoError(t, os.WriteFile(path, []byte("x\ny\n"), 0o600))
+	sp = newJob(t, "cat")
+	sp.Capture = true
+	sp.FeedFile(path)
+	require.NoError(t, sp.Run())
+	require.NoError(t, sp.Run())
+	require.Equal(t, "x\ny\n", sp.Stdout.String())
+
+	sp = newJob(t, "sort")
+	sp.Capture = true
+	sp.FeedFunc(func(w io.Writer) error {
+		_, err := io.WriteString(w, "id,n\n2,b\n1,a\n")
+		return err
+	})
+	require.NoError(t, sp.Run())
+	require.Equal(t, "1,a\n2,b\nid,n\n", sp.Stdout.String())
+}
+
+func TestSubprocess_StdinErrors(t *testing.T) {
+	sp := newJob(t, "cat")
+	sp.FeedFile(filepath.Join(t.TempDir(), "missing"))
+	err := sp.Run()
+	require.ErrorContains(t, err, "stdin: open")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, sp.Result().StdinErr, os.ErrNotExist)
+
+	boom := errors.New("boom")
+	sp = newJob(t, "cat")
+	sp.FeedFunc(func(w io.Writer) error {
+		return boom
+	})
+	require.ErrorIs(t, sp.Run(), boom)
+
+	// a process exiting without reading everything isn't an error
+	sp = newJob(t, "head", "-c", "1")
+	sp.FeedFunc(func(w io.Writer) error {
+		for {
+			if _, err := io.WriteString(w, strings.Repeat("x", 4096)); err != nil {
+				return err
+			}
+		}
+	})
+	require.NoError(t, sp.Run())
+}
+
+func TestSubprocess_StdInWriterClose(t *testing.T) {
+	sp := newJob(t, "cat")
+	sp.Capture = true
+	require.NoError(t, sp.Start())
+	_, err := io.WriteString(sp.StdInWriter, "hello\n")
+	require.NoError(t, err)
+	require.NoError(t, sp.StdInWriter.Close())
+
+	done := make(chan error)
+	go func() { done <- sp.Wait() }()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cat didn't exit after stdin was closed")
+	}
+	require.Equal(t, "hello\n", sp.Stdout.String())
+}
+
+func TestSubprocess_StdinBlocked(t *testing.T) {
+	// a Stdin still blocked in Read once the process has exited
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	sp := newJob(t, "true")
+	sp.Stdin = pr
+	sp.WaitDelay = 100 * time.Millisecond
+	sp.GracePeriod = 100 * time.Millisecond
+
+	start := time.Now()
+	require.NoError(t, sp.Run())
+	require.Less(t, time.Since(start), 2*time.Second)
+}
@@ -5,19 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"sync"
 	"time"
 
 	"cloud.google.com/go/bigquery/storage/managedwriter"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 type BigQueryStream struct {
-	client *managedwriter.Client
-	ms     *managedwriter.ManagedStream
+	ms *managedwriter.ManagedStream
+	// release closes the client, or lets go of a shared one
+	release func() error
 
 	ch chan []byte
 
@@ -37,20 +41,94 @@ type BigQueryStreamConfig struct {
 	FlushInterval time.Duration
 	AppendTimeout time.Duration
 
-	clientOpts []option.ClientOption
+	// ClientOptions configure the managed writer client (see WithMultiplexing, WithEmulator, etc).
+	ClientOptions []ClientOption
 }
 
 // NOTE: multiplexing with the managed writer is an experimental feature
-func (c BigQueryStreamConfig) AsMultiplexer(limit ...int) {
+func (c *BigQueryStreamConfig) AsMultiplexer(limit ...int) {
 	l := 10
 	if len(limit) > 0 {
 		l = limit[0]
 	}
 
-	c.clientOpts = append(c.clientOpts,
-		managedwriter.WithMultiplexing(),
-		managedwriter.WithMultiplexPoolLimit(l),
-	)
+	c.ClientOptions = append(c.ClientOptions, WithMultiplexing(l))
+}
+
+type clientSettings struct {
+	clientOpts []option.ClientOption
+	writerOpts []managedwriter.WriterOption
+}
+
+func (c BigQueryStreamConfig) settings() clientSettings {
+	var s clientSettings
+	for _, opt := range c.ClientOptions {
+		if opt != nil {
+			opt(&s)
+		}
+	}
+	return s
+}
+
+// ClientOption configures the managed writer client created by NewBigQueryStream.
+type ClientOption func(*clientSettings)
+
+// WithMultiplexing shares connections between streams, up to limit connections per region.
+// NOTE: multiplexing with the managed writer is an experimental feature
+func WithMultiplexing(limit int) ClientOption {
+	return func(s *clientSettings) {
+		s.clientOpts = append(s.clientOpts,
+			managedwriter.WithMultiplexing(),
+			managedwriter.WithMultiplexPoolLimit(limit),
+		)
+	}
+}
+
+// WithEndpoint overrides the Storage Write API endpoint (host:port).
+func WithEndpoint(endpoint string) ClientOption {
+	return func(s *clientSettings) {
+		s.clientOpts = append(s.clientOpts, option.WithEndpoint(endpoint))
+	}
+}
+
+// WithEmulator points the client at a local emulator: the endpoint is dialed
+// without TLS and without authentication.
+func WithEmulator(endpoint string) ClientOption {
+	return func(s *clientSettings) {
+		s.clientOpts = append(s.clientOpts,
+			option.WithEndpoint(endpoint),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+	}
+}
+
+// WithCredentialsFile authenticates with a service account or refresh token JSON file.
+func WithCredentialsFile(path string) ClientOption {
+	return func(s *clientSettings) {
+		s.clientOpts = append(s.clientOpts, option.WithCredentialsFile(path))
+	}
+}
+
+// WithCredentialsJSON authenticates with service account or refresh token JSON.
+func WithCredentialsJSON(b []byte) ClientOption {
+	return func(s *clientSettings) {
+		s.clientOpts = append(s.clientOpts, option.WithCredentialsJSON(b))
+	}
+}
+
+// WithTraceID prefixes requests made by the stream with traceID, for diagnostics.
+func WithTraceID(traceID string) ClientOption {
+	return func(s *clientSettings) {
+		s.writerOpts = append(s.writerOpts, managedwriter.WithTraceID(traceID))
+	}
+}
+
+// WithClientOptions passes opts through to managedwriter.NewClient as-is.
+func WithClientOptions(opts ...option.ClientOption) ClientOption {
+	return func(s *clientSettings) {
+		s.clientOpts = append(s.clientOpts, opts...)
+	}
 }
 
 func (c BigQueryStreamConfig) withDefaults() BigQueryStreamConfig {
@@ -72,6 +150,9 @@ func (c BigQueryStreamConfig) withDefaults() BigQueryStreamConfig {
 
 type Options func() *managedwriter.WriterOption
 
+// newClient is swapped out in tests
+var newClient = managedwriter.NewClient
+
 func CommittedStreamOpts(tableName string, descriptor *descriptorpb.DescriptorProto) (opts []managedwriter.WriterOption) {
 	opts = append(opts,
 		managedwriter.WithDestinationTable(tableName),
@@ -87,23 +168,31 @@ func NewBigQueryStream(ctx context.Context, projectId string, cfg BigQueryStream
 		return nil, errors.New("please provide options for stream (use CommittedStreamOpts)")
 	}
 	cfg = cfg.withDefaults()
+	settings := cfg.settings()
 
-	client, err := managedwriter.NewClient(ctx, projectId)
+	client, err := newClient(ctx, projectId, settings.clientOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("managedwriter.NewClient: %w", err)
 	}
+	return newBigQueryStream(ctx, client, client.Close, cfg, settings, opts)
+}
 
+// newBigQueryStream opens a stream with client, calling release when the
+// stream is closed or fails to open. cfg must have its defaults applied.
+func newBigQueryStream(ctx context.Context, client *managedwriter.Client, release func() error, cfg BigQueryStreamConfig, settings clientSettings, opts []managedwriter.WriterOption) (*BigQueryStream, error) {
+	// opts may be the caller's, with room to spare
+	opts = slices.Concat(opts, settings.writerOpts)
 	ms, err := client.NewManagedStream(ctx, opts...)
 	if err != nil {
-		_ = client.Close()
+		_ = release()
 		return nil, fmt.Errorf("client.NewManagedStream: %w", err)
 	}
 
 	runCtx, cancel := context.WithCancel(context.Background())
 
 	s := &BigQueryStream{
-		client:        client,
 		ms:            ms,
+		release:       release,
 		ch:            make(chan []byte, cfg.ChannelSize),
 		cancel:        cancel,
 		batchSize:     cfg.BatchSize,
@@ -138,8 +227,8 @@ func (s *BigQueryStream) Close() error {
 	if s.ms != nil {
 		err1 = s.ms.Close()
 	}
-	if s.client != nil {
-		err2 = s.client.Close()
+	if s.release != nil {
+		err2 = s.release()
 	}
 
 	return errors.Join(err1, err2)
@@ -248,6 +337,45 @@ func (s *BigQueryStream) recordErr(err error) {
 	log.Printf("bigquery stream error: %v\n", err)
 }
 
+// sharedClient is a managedwriter client shared by several streams. It is
+// created by the first to acquire it and closed when the last releases it.
+type sharedClient struct {
+	projectID string
+	opts      []option.ClientOption
+
+	mu     sync.Mutex
+	client *managedwriter.Client
+	refs   int
+}
+
+func (c *sharedClient) acquire(ctx context.Context) (*managedwriter.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client == nil {
+		client, err := newClient(ctx, c.projectID, c.opts...)
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	c.refs++
+	return c.client, nil
+}
+
+func (c *sharedClient) release() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	client := c.client
+	c.client = nil
+	return client.Close()
+}
+
 type StreamOutcome int
 
 const (
@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func optTypes(opts []option.ClientOption) []string {
+	types := make([]string, len(opts))
+	for i, opt := range opts {
+		types[i] = fmt.Sprintf("%T", opt)
+	}
+	return types
+}
+
+func TestBigQueryStreamConfig_AsMultiplexer(t *testing.T) {
+	cfg := BigQueryStreamConfig{}
+	cfg.AsMultiplexer(4)
+	require.Len(t, cfg.ClientOptions, 1)
+
+	got := optTypes(cfg.settings().clientOpts)
+	require.Equal(t, optTypes([]option.ClientOption{
+		managedwriter.WithMultiplexing(),
+		managedwriter.WithMultiplexPoolLimit(4),
+	}), got)
+}
+
+func TestBigQueryStreamConfig_Settings(t *testing.T) {
+	cfg := BigQueryStreamConfig{
+		ClientOptions: []ClientOption{
+			WithEmulator("localhost:9060"),
+			WithCredentialsFile("/tmp/creds.json"),
+			WithTraceID("replay"),
+			WithClientOptions(option.WithQuotaProject("billing")),
+			nil,
+		},
+	}
+
+	s := cfg.settings()
+	require.Len(t, s.clientOpts, 5)
+	require.Len(t, s.writerOpts, 1)
+	require.Equal(t, "localhost:9060", fmt.Sprint(s.clientOpts[0]))
+	require.Equal(t, "/tmp/creds.json", fmt.Sprint(s.clientOpts[3]))
+	require.Equal(t, "billing", fmt.Sprint(s.clientOpts[4]))
+}
+
+func TestNewBigQueryStream_AppliesClientOptions(t *testing.T) {
+	var gotProject string
+	var gotOpts []option.ClientOption
+	stop := errors.New("stop")
+
+	orig := newClient
+	t.Cleanup(func() { newClient = orig })
+	newClient = func(_ context.Context, projectID string, opts ...option.ClientOption) (*managedwriter.Client, error) {
+		gotProject, gotOpts = projectID, opts
+		return nil, stop
+	}
+
+	cfg := BigQueryStreamConfig{ClientOptions: []ClientOption{WithEndpoint("localhost:9060")}}
+	cfg.AsMultiplexer()
+
+	_, err := NewBigQueryStream(context.Background(), "proj", cfg, managedwriter.WithDestinationTable("t"))
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, "proj", gotProject)
+	require.Equal(t, optTypes(cfg.settings().clientOpts), optTypes(gotOpts))
+	require.Len(t, gotOpts, 3)
+	require.Equal(t, "localhost:9060", fmt.Sprint(gotOpts[0]))
+}
+
+func TestSharedClient(t *testing.T) {
+	calls := 0
+	orig := newClient
+	t.Cleanup(func() { newClient = orig })
+	newClient = func(ctx context.Context, projectID string, opts ...option.ClientOption) (*managedwriter.Client, error) {
+		calls++
+		return orig(ctx, projectID, opts...)
+	}
+
+	ctx := context.Background()
+	c := &sharedClient{projectID: "proj", opts: []option.ClientOption{
+		option.WithEndpoint("localhost:9060"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}}
+
+	a, err := c.acquire(ctx)
+	require.NoError(t, err)
+	b, err := c.acquire(ctx)
+	require.NoError(t, err)
+	require.Same(t, a, b)
+	require.Equal(t, 1, calls)
+
+	require.NoError(t, c.release())
+	require.NotNil(t, c.client)
+	require.NoError(t, c.release())
+	require.Nil(t, c.client)
+
+	_, err = c.acquire(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+	require.NoError(t, c.release())
+}
+
+func TestNewBigQueryStream_KeepsCallerOptions(t *testing.T) {
+	cfg := BigQueryStreamConfig{ClientOptions: []ClientOption{WithEmulator("localhost:1"), WithTraceID("test")}}
+
+	opts := make([]managedwriter.WriterOption, 1, 2)
+	opts[0] = managedwriter.WithDestinationTable("projects/p/datasets/d/tables/t")
+	spare := opts[:2]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := NewBigQueryStream(ctx, "proj", cfg, opts...)
+	require.Error(t, err)
+	require.Nil(t, spare[1])
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
 	"time"
 
@@ -57,6 +57,8 @@ func (c PushHandlerConfig) withDefaults() PushHandlerConfig {
 
 type RowSerializer func(raw []byte, attrs map[string]string) ([]byte, error)
 
+// NewPushHandler logs through p.FromContext, so wrap it in p.TraceMiddleware
+// to correlate its logs with the push request.
 func NewPushHandler(stream Stream, serialize RowSerializer, cfg PushHandlerConfig) http.HandlerFunc {
 	cfg = cfg.withDefaults()
 	sem := make(chan struct{}, cfg.MaxConcurrency)
@@ -98,14 +100,14 @@ func NewPushHandler(stream Stream, serialize RowSerializer, cfg PushHandlerConfi
 
 		raw, err := base64.StdEncoding.DecodeString(env.Message.Data)
 		if err != nil {
-			log.Printf("poison message (invalid base64) messageId=%s err=%v\n", env.Message.MessageId, err)
+			p.FromContext(ctx).With("messageId", env.Message.MessageId, "err", err).Warning("poison message (invalid base64)")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
 		row, err := serialize(raw, env.Message.Attributes)
 		if err != nil {
-			log.Printf("poison message (serialization failed) messageId=%s err=%v\n", env.Message.MessageId, err)
+			p.FromContext(ctx).With("messageId", env.Message.MessageId, "err", err).Warning("poison message (serialization failed)")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -114,6 +116,11 @@ func NewPushHandler(stream Stream, serialize RowSerializer, cfg PushHandlerConfi
 		defer cancel()
 
 		if err := stream.Append(qctx, row); err != nil {
+			if errors.Is(err, ErrRowRejected) {
+				p.FromContext(ctx).With("messageId", env.Message.MessageId, "err", err).Warning("poison message (rejected by stream)")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 			http.Error(w, p.Format("enqueue failed; %v", err), http.StatusServiceUnavailable)
 			return
 		}
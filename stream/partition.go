@@ -0,0 +1,253 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	// ErrRowRejected is returned for rows that will never be accepted, so retrying is pointless.
+	ErrRowRejected = errors.New("row rejected")
+	ErrLateRow     = fmt.Errorf("%w: outside allowed lateness window", ErrRowRejected)
+	ErrFutureRow   = fmt.Errorf("%w: beyond allowed skew into the future", ErrRowRejected)
+	ErrNoTimestamp = fmt.Errorf("%w: could not extract event timestamp", ErrRowRejected)
+
+	// ErrShutdown is returned by Append after Shutdown.
+	ErrShutdown = errors.New("partitioned stream is shut down")
+)
+
+type Partitioning int
+
+const (
+	PartitionDay Partitioning = iota
+	PartitionHour
+	PartitionMonth
+	PartitionYear
+)
+
+func (pt Partitioning) layout() string {
+	switch pt {
+	default:
+		return "20060102"
+	case PartitionHour:
+		return "2006010215"
+	case PartitionMonth:
+		return "200601"
+	case PartitionYear:
+		return "2006"
+	}
+}
+
+// Decorator returns the partition ID of t (in UTC), e.g. "20260101" for daily partitions.
+func (pt Partitioning) Decorator(t time.Time) string {
+	return t.UTC().Format(pt.layout())
+}
+
+// PartitionTable returns table with the partition decorator of t, e.g. "table$20260101".
+func PartitionTable(table string, t time.Time, pt Partitioning) string {
+	return table + "$" + pt.Decorator(t)
+}
+
+// TimestampFunc extracts the event time of a row.
+type TimestampFunc func(row []byte) (time.Time, error)
+
+// StreamFactory opens a stream for a partition decorator (see Partitioning.Decorator).
+type StreamFactory func(ctx context.Context, partition string) (Stream, error)
+
+type PartitionedStreamConfig struct {
+	Partitioning Partitioning
+	Timestamp    TimestampFunc
+	Open         StreamFactory
+	// MaxLateness rejects rows whose event time is older than now - MaxLateness.
+	// Zero allows any lateness.
+	MaxLateness time.Duration
+	// MaxSkew rejects rows whose event time is later than now + MaxSkew.
+	// Zero allows any time in the future.
+	MaxSkew time.Duration
+	// DeadLetter, if set, receives rejected rows instead of Append returning an error.
+	DeadLetter Stream
+
+	now func() time.Time
+}
+
+// PartitionedStream routes each row to the stream of the partition its event time falls in.
+// Streams are opened lazily on the first row of each partition.
+type PartitionedStream struct {
+	cfg PartitionedStreamConfig
+
+	mu       sync.Mutex
+	streams  map[string]*partition
+	shutdown bool
+	// appends in flight, which Shutdown waits for
+	appending sync.WaitGroup
+}
+
+// partition is a stream being opened, ready once opened (or failed to).
+type partition struct {
+	ready  chan struct{}
+	stream Stream
+	err    error
+}
+
+func NewPartitionedStream(cfg PartitionedStreamConfig) (*PartitionedStream, error) {
+	if cfg.Timestamp == nil {
+		return nil, errors.New("please provide a Timestamp func")
+	}
+	if cfg.Open == nil {
+		return nil, errors.New("please provide an Open func (use BigQueryPartitionFactory)")
+	}
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+
+	return &PartitionedStream{
+		cfg:     cfg,
+		streams: make(map[string]*partition),
+	}, nil
+}
+
+func (s *PartitionedStream) Append(ctx context.Context, row []byte) error {
+	ts, err := s.cfg.Timestamp(row)
+	if err != nil {
+		return s.reject(ctx, row, fmt.Errorf("%w: %v", ErrNoTimestamp, err))
+	}
+
+	now := s.cfg.now()
+	if s.cfg.MaxLateness > 0 && ts.Before(now.Add(-s.cfg.MaxLateness)) {
+		return s.reject(ctx, row, fmt.Errorf("%w: event time %s", ErrLateRow, ts.Format(time.RFC3339)))
+	}
+	if s.cfg.MaxSkew > 0 && ts.After(now.Add(s.cfg.MaxSkew)) {
+		return s.reject(ctx, row, fmt.Errorf("%w: event time %s", ErrFutureRow, ts.Format(time.RFC3339)))
+	}
+
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return ErrShutdown
+	}
+	s.appending.Add(1)
+	s.mu.Unlock()
+	defer s.appending.Done()
+
+	stream, err := s.stream(ctx, s.cfg.Partitioning.Decorator(ts))
+	if err != nil {
+		return err
+	}
+
+	return stream.Append(ctx, row)
+}
+
+func (s *PartitionedStream) reject(ctx context.Context, row []byte, err error) error {
+	if s.cfg.DeadLetter == nil {
+		return err
+	}
+	if dlErr := s.cfg.DeadLetter.Append(ctx, row); dlErr != nil {
+		return fmt.Errorf("dead letter: %w (rejected: %v)", dlErr, err)
+	}
+	return nil
+}
+
+// stream returns the stream of the partition, opening it if needed. Streams
+// are opened without holding s.mu, so other partitions aren't held up, and
+// rows for a partition being opened wait for it.
+func (s *PartitionedStream) stream(ctx context.Context, decorator string) (Stream, error) {
+	s.mu.Lock()
+	pt, ok := s.streams[decorator]
+	if !ok {
+		pt = &partition{ready: make(chan struct{})}
+		s.streams[decorator] = pt
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		pt.stream, pt.err = s.cfg.Open(ctx, decorator)
+		if pt.err != nil {
+			// let the next row try again
+			s.mu.Lock()
+			delete(s.streams, decorator)
+			s.mu.Unlock()
+		}
+		close(pt.ready)
+	}
+
+	select {
+	case <-pt.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if pt.err != nil {
+		return nil, fmt.Errorf("open partition %s: %w", decorator, pt.err)
+	}
+	return pt.stream, nil
+}
+
+// Partitions returns the decorators of the partitions opened so far.
+func (s *PartitionedStream) Partitions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ret []string
+	for decorator, pt := range s.streams {
+		select {
+		case <-pt.ready:
+			ret = append(ret, decorator)
+		default:
+		}
+	}
+	return ret
+}
+
+// Shutdown waits for appends in flight, including partitions being opened,
+// then shuts down every partition stream that supports it. Append fails with
+// ErrShutdown once Shutdown has been called.
+func (s *PartitionedStream) Shutdown() error {
+	s.mu.Lock()
+	s.shutdown = true
+	s.mu.Unlock()
+	s.appending.Wait()
+
+	s.mu.Lock()
+	streams := s.streams
+	s.streams = make(map[string]*partition)
+	s.mu.Unlock()
+
+	var errs []error
+	for decorator, pt := range streams {
+		// every partition is ready: it is opened by an append
+		if sd, ok := pt.stream.(interface{ Shutdown() error }); ok {
+			if err := sd.Shutdown(); err != nil {
+				errs = append(errs, fmt.Errorf("partition %s: %w", decorator, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// BigQueryPartitionFactory opens a committed BigQueryStream per partition of table,
+// which is given as "projects/{p}/datasets/{d}/tables/{t}". The streams share
+// one managedwriter client, closed along with the last of them.
+func BigQueryPartitionFactory(projectId, table string, cfg BigQueryStreamConfig, descriptor *descriptorpb.DescriptorProto, opts ...managedwriter.WriterOption) StreamFactory {
+	cfg = cfg.withDefaults()
+	settings := cfg.settings()
+	shared := &sharedClient{projectID: projectId, opts: settings.clientOpts}
+
+	return func(ctx context.Context, partition string) (Stream, error) {
+		client, err := shared.acquire(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("managedwriter.NewClient: %w", err)
+		}
+		o := append(CommittedStreamOpts(table+"$"+partition, descriptor), opts...)
+		s, err := newBigQueryStream(ctx, client, shared.release, cfg, settings, o)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
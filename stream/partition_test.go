@@ -0,0 +1,197 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitioning_Decorator(t *testing.T) {
+	ts := time.Date(2026, 1, 2, 15, 4, 5, 0, time.FixedZone("EST", -5*3600))
+
+	require.Equal(t, "20260102", PartitionDay.Decorator(ts))
+	require.Equal(t, "2026010220", PartitionHour.Decorator(ts))
+	require.Equal(t, "202601", PartitionMonth.Decorator(ts))
+	require.Equal(t, "2026", PartitionYear.Decorator(ts))
+	require.Equal(t, "events$20260102", PartitionTable("events", ts, PartitionDay))
+}
+
+func TestPartitionedStream(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	opened := map[string]*recordStream{}
+	dead := &recordStream{}
+
+	s, err := NewPartitionedStream(PartitionedStreamConfig{
+		Timestamp: func(row []byte) (time.Time, error) {
+			return time.Parse(time.RFC3339, string(row))
+		},
+		Open: func(_ context.Context, partition string) (Stream, error) {
+			rs := &recordStream{}
+			opened[partition] = rs
+			return rs, nil
+		},
+		MaxLateness: 48 * time.Hour,
+		MaxSkew:     time.Hour,
+		now:         func() time.Time { return now },
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, s.Append(ctx, []byte("2026-01-10T01:00:00Z")))
+	require.NoError(t, s.Append(ctx, []byte("2026-01-10T11:00:00Z")))
+	require.NoError(t, s.Append(ctx, []byte("2026-01-09T00:00:00Z")))
+
+	err = s.Append(ctx, []byte("2026-01-01T00:00:00Z"))
+	require.ErrorIs(t, err, ErrLateRow)
+	require.ErrorIs(t, err, ErrRowRejected)
+	err = s.Append(ctx, []byte("2026-01-10T14:00:00Z"))
+	require.ErrorIs(t, err, ErrFutureRow)
+	require.ErrorIs(t, err, ErrRowRejected)
+	require.ErrorIs(t, s.Append(ctx, []byte("garbage")), ErrNoTimestamp)
+
+	partitions := s.Partitions()
+	sort.Strings(partitions)
+	require.Equal(t, []string{"20260109", "20260110"}, partitions)
+	require.Len(t, opened["20260110"].rows, 2)
+	require.Len(t, opened["20260109"].rows, 1)
+
+	s.cfg.DeadLetter = dead
+	require.NoError(t, s.Append(ctx, []byte("2026-01-01T00:00:00Z")))
+	require.NoError(t, s.Append(ctx, []byte("garbage")))
+	require.Equal(t, []string{"2026-01-01T00:00:00Z", "garbage"}, dead.rows)
+
+	require.NoError(t, s.Shutdown())
+	require.Empty(t, s.Partitions())
+	require.ErrorIs(t, s.Append(ctx, []byte("2026-01-10T11:00:00Z")), ErrShutdown)
+	require.Len(t, opened, 2)
+}
+
+func TestPartitionedStream_SlowOpen(t *testing.T) {
+	block := make(chan struct{})
+	var mu sync.Mutex
+	opens := map[string]int{}
+
+	s, err := NewPartitionedStream(PartitionedStreamConfig{
+		Timestamp: func(row []byte) (time.Time, error) {
+			return time.Parse(time.RFC3339, string(row))
+		},
+		Open: func(_ context.Context, partition string) (Stream, error) {
+			mu.Lock()
+			opens[partition]++
+			mu.Unlock()
+			if partition == "20260101" {
+				<-block
+			}
+			return &recordStream{}, nil
+		},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// rows for the partition being opened wait for it
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, s.Append(ctx, []byte("2026-01-01T00:00:00Z")))
+		}()
+	}
+
+	// while other partitions aren't held up
+	require.NoError(t, s.Append(ctx, []byte("2026-01-02T00:00:00Z")))
+	require.Equal(t, []string{"20260102"}, s.Partitions())
+
+	close(block)
+	wg.Wait()
+	require.Equal(t, map[string]int{"20260101": 1, "20260102": 1}, opens)
+}
+
+func TestPushHandler_RejectedRowIsAcked(t *testing.T) {
+	s, err := NewPartitionedStream(PartitionedStreamConfig{
+		Timestamp: func([]byte) (time.Time, error) { return time.Time{}, errors.New("no ts") },
+		Open:      func(context.Context, string) (Stream, error) { return &recordStream{}, nil },
+	})
+	require.NoError(t, err)
+
+	serializer := func(raw []byte, _ map[string]string) ([]byte, error) {
+		return raw, nil
+	}
+
+	h := NewPushHandler(s, serializer, PushHandlerConfig{})
+	rec := httptest.NewRecorder()
+
+	req := httptest.NewRequest(http.MethodPost, "/", requestBody(t, []byte("test")))
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+// chanStream closes its channel on Shutdown, like BigQueryStream.
+type chanStream struct {
+	ch chan []byte
+}
+
+func (s *chanStream) Append(ctx context.Context, row []byte) error {
+	select {
+	case s.ch <- row:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *chanStream) Shutdown() error {
+	close(s.ch)
+	return nil
+}
+
+func TestPartitionedStream_ConcurrentShutdown(t *testing.T) {
+	s, err := NewPartitionedStream(PartitionedStreamConfig{
+		Timestamp: func(row []byte) (time.Time, error) {
+			return time.Parse(time.RFC3339, string(row))
+		},
+		Open: func(context.Context, string) (Stream, error) {
+			cs := &chanStream{ch: make(chan []byte)}
+			go func() {
+				for range cs.ch {
+				}
+			}()
+			return cs, nil
+		},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			row := []byte(fmt.Sprintf("2026-01-0%dT00:00:00Z", i%3+1))
+			for {
+				if err := s.Append(ctx, row); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, s.Shutdown())
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.ErrorIs(t, err, ErrShutdown)
+	}
+}
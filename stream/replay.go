@@ -0,0 +1,393 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ReplayFormat int
+
+const (
+	// ReplayJSONL reads one row per line.
+	ReplayJSONL ReplayFormat = iota
+	// ReplayMessages reads one Pub/Sub message per line, either as a
+	// PushEnvelope or a bare Message. The base64 data is decoded and passed,
+	// along with its attributes, to the serializer.
+	ReplayMessages
+	// ReplayCSV reads a header row followed by records, each of which is
+	// converted to a JSON object keyed by the header. Empty values are omitted.
+	ReplayCSV
+)
+
+func ParseReplayFormat(s string) (ReplayFormat, error) {
+	switch strings.ToLower(s) {
+	default:
+		return 0, fmt.Errorf("unknown replay format: %q", s)
+	case "jsonl", "json", "ndjson":
+		return ReplayJSONL, nil
+	case "messages", "pubsub":
+		return ReplayMessages, nil
+	case "csv":
+		return ReplayCSV, nil
+	}
+}
+
+type ReplayConfig struct {
+	Format ReplayFormat
+	// Serialize, if set, is re-run on every record before it is appended.
+	Serialize RowSerializer
+	// SkipInvalid counts and skips records that cannot be decoded or
+	// serialized instead of aborting the replay. Errors reading the input
+	// (e.g. a truncated gzip file) still abort it.
+	SkipInvalid bool
+	// RowsPerSecond caps throughput. Zero means unlimited, as does anything
+	// over a billion.
+	RowsPerSecond int
+	// AppendTimeout bounds each call to Stream.Append.
+	AppendTimeout time.Duration
+	// Checkpoint is the path of a file holding the number of records already
+	// replayed. Those records are skipped on start, and the file is updated
+	// every CheckpointEvery records and once more when the replay ends.
+	// NOTE: for buffered streams (e.g. BigQueryStream) a record counts as
+	// replayed once it has been enqueued, not once it has been committed.
+	Checkpoint      string
+	CheckpointEvery int
+	// Progress is called every ProgressInterval and once when the replay ends.
+	Progress         func(ReplayStats)
+	ProgressInterval time.Duration
+}
+
+func (c ReplayConfig) withDefaults() ReplayConfig {
+	if c.AppendTimeout <= 0 {
+		c.AppendTimeout = 15 * time.Second
+	}
+	if c.CheckpointEvery <= 0 {
+		c.CheckpointEvery = 1000
+	}
+	if c.ProgressInterval <= 0 {
+		c.ProgressInterval = 5 * time.Second
+	}
+
+	return c
+}
+
+type ReplayStats struct {
+	// Resumed is the number of records skipped because of a checkpoint.
+	Resumed  int64
+	Read     int64
+	Appended int64
+	Invalid  int64
+	Elapsed  time.Duration
+}
+
+// Offset is the number of records consumed from the input so far,
+// i.e. the value written to the checkpoint.
+func (s ReplayStats) Offset() int64 {
+	return s.Resumed + s.Read
+}
+
+func (s ReplayStats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Appended) / s.Elapsed.Seconds()
+}
+
+func (s ReplayStats) String() string {
+	return fmt.Sprintf(
+		"read=%d appended=%d invalid=%d resumed=%d elapsed=%s rate=%.1f/s",
+		s.Read, s.Appended, s.Invalid, s.Resumed, s.Elapsed.Round(time.Millisecond), s.Rate(),
+	)
+}
+
+type replayRecord struct {
+	data  []byte
+	attrs map[string]string
+}
+
+// invalidRecordError is a record that was read but couldn't be decoded,
+// which SkipInvalid skips, unlike errors reading the input.
+type invalidRecordError struct{ err error }
+
+func (e invalidRecordError) Error() string { return e.err.Error() }
+func (e invalidRecordError) Unwrap() error { return e.err }
+
+func invalidRecord(err error) error {
+	if err == nil {
+		return nil
+	}
+	return invalidRecordError{err}
+}
+
+// skippable reports whether err is an invalid record, not a read error.
+func skippable(err error) bool {
+	var invalid invalidRecordError
+	return errors.As(err, &invalid)
+}
+
+// Replay reads archived or dead-lettered records from r and appends them to stream.
+// It returns the stats of the replay, which are valid even if an error is returned.
+func Replay(ctx context.Context, stream Stream, r io.Reader, cfg ReplayConfig) (ReplayStats, error) {
+	cfg = cfg.withDefaults()
+	start := time.Now()
+
+	var stats ReplayStats
+	if cfg.Checkpoint != "" {
+		offset, err := readCheckpoint(cfg.Checkpoint)
+		if err != nil {
+			return stats, err
+		}
+		stats.Resumed = offset
+	}
+
+	next, err := newRecordReader(r, cfg.Format)
+	if err != nil {
+		return stats, err
+	}
+
+	for range stats.Resumed {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if _, err := next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return stats, fmt.Errorf("checkpoint %d is past the end of input", stats.Resumed)
+			}
+			if !cfg.SkipInvalid || !skippable(err) {
+				return stats, err
+			}
+		}
+	}
+
+	var limit <-chan time.Time
+	if cfg.RowsPerSecond > 0 && cfg.RowsPerSecond <= int(time.Second) {
+		t := time.NewTicker(time.Second / time.Duration(cfg.RowsPerSecond))
+		defer t.Stop()
+		limit = t.C
+	}
+
+	progress := time.NewTicker(cfg.ProgressInterval)
+	defer progress.Stop()
+
+	checkpoint := func() error {
+		if cfg.Checkpoint == "" {
+			return nil
+		}
+		return writeCheckpoint(cfg.Checkpoint, stats.Offset())
+	}
+
+	finish := func(err error) (ReplayStats, error) {
+		stats.Elapsed = time.Since(start)
+		if cerr := checkpoint(); cerr != nil {
+			err = errors.Join(err, cerr)
+		}
+		if cfg.Progress != nil {
+			cfg.Progress(stats)
+		}
+		return stats, err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return finish(err)
+		}
+
+		rec, err := next()
+		if errors.Is(err, io.EOF) {
+			return finish(nil)
+		}
+
+		if err != nil && !skippable(err) {
+			return finish(fmt.Errorf("record %d: %w", stats.Offset()+1, err))
+		}
+
+		row := rec.data
+		if err == nil && cfg.Serialize != nil {
+			row, err = cfg.Serialize(rec.data, rec.attrs)
+		}
+		if err != nil {
+			if !cfg.SkipInvalid {
+				return finish(fmt.Errorf("record %d: %w", stats.Offset()+1, err))
+			}
+			stats.Read++
+			stats.Invalid++
+			continue
+		}
+
+		if limit != nil {
+			select {
+			case <-ctx.Done():
+				return finish(ctx.Err())
+			case <-limit:
+			}
+		}
+
+		actx, cancel := context.WithTimeout(ctx, cfg.AppendTimeout)
+		err = stream.Append(actx, row)
+		cancel()
+		if err != nil {
+			return finish(fmt.Errorf("stream.Append: %w", err))
+		}
+
+		stats.Read++
+		stats.Appended++
+
+		if stats.Read%int64(cfg.CheckpointEvery) == 0 {
+			if err := checkpoint(); err != nil {
+				return finish(err)
+			}
+		}
+
+		select {
+		default:
+		case <-progress.C:
+			if cfg.Progress != nil {
+				stats.Elapsed = time.Since(start)
+				cfg.Progress(stats)
+			}
+		}
+	}
+}
+
+// newRecordReader returns a function yielding the next record of r.
+// It returns io.EOF once the input is exhausted, and an invalidRecordError
+// for records that were read but couldn't be decoded.
+func newRecordReader(r io.Reader, format ReplayFormat) (func() (replayRecord, error), error) {
+	switch format {
+	default:
+		return nil, fmt.Errorf("unknown replay format: %d", format)
+	case ReplayJSONL:
+		next := lineReader(r)
+		return func() (replayRecord, error) {
+			line, err := next()
+			return replayRecord{data: line}, err
+		}, nil
+	case ReplayMessages:
+		next := lineReader(r)
+		return func() (replayRecord, error) {
+			line, err := next()
+			if err != nil {
+				return replayRecord{}, err
+			}
+			rec, err := decodeMessage(line)
+			return rec, invalidRecord(err)
+		}, nil
+	case ReplayCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return func() (replayRecord, error) { return replayRecord{}, io.EOF }, nil
+			}
+			return nil, fmt.Errorf("csv.Read: %w", err)
+		}
+
+		return func() (replayRecord, error) {
+			fields, err := cr.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return replayRecord{}, err
+				}
+				err = fmt.Errorf("csv.Read: %w", err)
+				// the reader carries on after a malformed record
+				var perr *csv.ParseError
+				if errors.As(err, &perr) {
+					err = invalidRecord(err)
+				}
+				return replayRecord{}, err
+			}
+
+			obj := make(map[string]string, len(header))
+			for i, v := range fields {
+				if i < len(header) && v != "" {
+					obj[header[i]] = v
+				}
+			}
+			data, err := json.Marshal(obj)
+			return replayRecord{data: data}, invalidRecord(err)
+		}, nil
+	}
+}
+
+// lineReader yields non-blank lines of r without a size limit.
+func lineReader(r io.Reader) func() ([]byte, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	return func() ([]byte, error) {
+		for {
+			line, err := br.ReadBytes('\n')
+			line = bytes.TrimSpace(line)
+			if len(line) > 0 {
+				return line, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func decodeMessage(line []byte) (replayRecord, error) {
+	var env PushEnvelope
+	if err := json.Unmarshal(line, &env); err != nil {
+		return replayRecord{}, fmt.Errorf("invalid message: %w", err)
+	}
+
+	msg := env.Message
+	if msg.Data == "" {
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return replayRecord{}, fmt.Errorf("invalid message: %w", err)
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(msg.Data)
+	if err != nil {
+		return replayRecord{}, fmt.Errorf("invalid base64 in message %s: %w", msg.MessageId, err)
+	}
+
+	return replayRecord{data: data, attrs: msg.Attributes}, nil
+}
+
+func readCheckpoint(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read checkpoint: %w", err)
+	}
+
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid checkpoint %s: %q", path, s)
+	}
+
+	return offset, nil
+}
+
+// writeCheckpoint replaces the checkpoint atomically so that a crash never leaves it truncated.
+func writeCheckpoint(path string, offset int64) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordStream struct {
+	mu   sync.Mutex
+	rows []string
+	fail int
+}
+
+func (s *recordStream) Append(_ context.Context, row []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail > 0 && len(s.rows) == s.fail {
+		return errors.New("unavailable")
+	}
+	s.rows = append(s.rows, string(row))
+	return nil
+}
+
+func TestReplay_JSONL(t *testing.T) {
+	in := "{\"a\":1}\n\n{\"a\":2}\n{\"a\":3}"
+	s := &recordStream{}
+
+	stats, err := Replay(context.Background(), s, strings.NewReader(in), ReplayConfig{})
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"a":1}`, `{"a":2}`, `{"a":3}`}, s.rows)
+	require.Equal(t, int64(3), stats.Appended)
+}
+
+func TestReplay_MessagesWithSerializer(t *testing.T) {
+	enc := base64.StdEncoding.EncodeToString
+	in := `{"message":{"data":"` + enc([]byte("one")) + `","attributes":{"k":"v"}}}` + "\n" +
+		`{"data":"` + enc([]byte("two")) + `"}` + "\n" +
+		`{"data":"!!!"}` + "\n"
+
+	var attrs []map[string]string
+	serialize := func(raw []byte, a map[string]string) ([]byte, error) {
+		attrs = append(attrs, a)
+		return []byte(strings.ToUpper(string(raw))), nil
+	}
+
+	s := &recordStream{}
+	_, err := Replay(context.Background(), s, strings.NewReader(in), ReplayConfig{
+		Format:    ReplayMessages,
+		Serialize: serialize,
+	})
+	require.Error(t, err)
+	require.Equal(t, []string{"ONE", "TWO"}, s.rows)
+	require.Equal(t, map[string]string{"k": "v"}, attrs[0])
+
+	s = &recordStream{}
+	stats, err := Replay(context.Background(), s, strings.NewReader(in), ReplayConfig{
+		Format:      ReplayMessages,
+		Serialize:   serialize,
+		SkipInvalid: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Invalid)
+	require.Equal(t, int64(3), stats.Read)
+}
+
+func TestReplay_CSV(t *testing.T) {
+	in := "id,name\n1,foo\n2,\n"
+	s := &recordStream{}
+
+	_, err := Replay(context.Background(), s, strings.NewReader(in), ReplayConfig{Format: ReplayCSV})
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"id":"1","name":"foo"}`, `{"id":"2"}`}, s.rows)
+}
+
+func TestReplay_TruncatedInput(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for i := range 1000 {
+		fmt.Fprintf(zw, "{\"n\":%d}\n", i)
+	}
+	require.NoError(t, zw.Close())
+	path := filepath.Join(t.TempDir(), "rows.jsonl.gz")
+	require.NoError(t, os.WriteFile(path, buf.Bytes()[:buf.Len()/2], 0o600))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	// read errors aren't skipped as invalid records
+	s := &recordStream{}
+	stats, err := Replay(context.Background(), s, zr, ReplayConfig{SkipInvalid: true})
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Zero(t, stats.Invalid)
+	require.Equal(t, stats.Appended, int64(len(s.rows)))
+}
+
+func TestReplay_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := &recordStream{}
+	stats, err := Replay(ctx, s, strings.NewReader("1\n2\n3\n"), ReplayConfig{})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, s.rows)
+	require.Zero(t, stats.Read)
+
+	// too fast to limit
+	_, err = Replay(context.Background(), s, strings.NewReader("1\n2\n3\n"), ReplayConfig{RowsPerSecond: 2e9})
+	require.NoError(t, err)
+	require.Len(t, s.rows, 3)
+}
+
+func TestReplay_Checkpoint(t *testing.T) {
+	in := "1\n2\n3\n4\n5\n"
+	ckpt := filepath.Join(t.TempDir(), "replay.ckpt")
+	cfg := ReplayConfig{Checkpoint: ckpt, CheckpointEvery: 1}
+
+	s := &recordStream{fail: 2}
+	stats, err := Replay(context.Background(), s, strings.NewReader(in), cfg)
+	require.Error(t, err)
+	require.Equal(t, int64(2), stats.Offset())
+
+	b, err := os.ReadFile(ckpt)
+	require.NoError(t, err)
+	require.Equal(t, "2\n", string(b))
+
+	s = &recordStream{}
+	stats, err = Replay(context.Background(), s, strings.NewReader(in), cfg)
+	require.NoError(t, err)
+	require.Equal(t, []string{"3", "4", "5"}, s.rows)
+	require.Equal(t, int64(2), stats.Resumed)
+	require.Equal(t, int64(5), stats.Offset())
+}
+
+func TestReplay_Progress(t *testing.T) {
+	var calls []ReplayStats
+	_, err := Replay(context.Background(), &recordStream{}, strings.NewReader("1\n2\n"), ReplayConfig{
+		RowsPerSecond: 1000,
+		Progress:      func(s ReplayStats) { calls = append(calls, s) },
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, calls)
+	require.Equal(t, int64(2), calls[len(calls)-1].Appended)
+}
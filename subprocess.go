@@ -4,40 +4,21 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
 type Scanner func(stderr bool, text string)
 
-type Session struct {
-	Subprocess *Subprocess
-	Alias      map[string]string
-	Env        map[string]string
-	mu         sync.Mutex
-}
-
-func NewSession() *Session {
-	return &Session{
-		Alias: make(map[string]string),
-		Env:   make(map[string]string),
-	}
-}
-
-func (s *Session) AddAlias(k, v string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.Alias[k] = v
-}
-
 type Subprocess struct {
 	WorkingDir                   string
 	Bin                          string
@@ -52,10 +33,74 @@ type Subprocess struct {
 	StderrReader, StdoutReader   io.ReadCloser
 	stderrScanner, stdoutScanner *bufio.Scanner
 	Scanner                      Scanner
-	StdInWriter                  io.Writer
-	Nice, Pid                    int
-	Capture, Print               bool
-	Label                        Label
+	// Stdin, if set, is copied to the process's stdin, which is closed at
+	// EOF; StdInWriter is nil then. Otherwise write to StdInWriter and close
+	// it when done, as commands reading stdin to EOF won't exit before.
+	Stdin          io.Reader
+	StdInWriter    io.WriteCloser
+	Nice, Pid      int
+	Capture, Print bool
+	// CaptureConfig bounds what Capture keeps.
+	CaptureConfig CaptureConfig
+	Label         Label
+	// Split sets how output is split into the tokens passed to Scanner,
+	// captured and printed; SplitFunc overrides it. Tokens longer than
+	// MaxTokenSize (1MB by default) stop scanning, and the rest of the
+	// stream is discarded and reported by Wait.
+	Split        SplitMode
+	SplitFunc    bufio.SplitFunc
+	MaxTokenSize int
+	// StdoutWriter and StderrWriter, if set, receive the raw stream instead
+	// of it being scanned (so Capture, Scanner and Print don't see it).
+	StdoutWriter, StderrWriter io.Writer
+	// PTY runs the process attached to a new pseudo-terminal (Linux only),
+	// for commands that only print progress or colors to a terminal. Stdout
+	// and stderr arrive merged as stdout, and the terminal echoes input.
+	// Closing StdInWriter sends EOF (Ctrl-D). StdoutWriter and StderrWriter
+	// are ignored, and it can't be used in a Pipeline. See also SetWindowSize.
+	PTY bool
+	// StopSignal is sent to the process group when Context is done or Stop
+	// is called, os.Interrupt by default. If the group hasn't exited after
+	// GracePeriod (5s by default), it is killed.
+	StopSignal  os.Signal
+	GracePeriod time.Duration
+	// NoProcessGroup leaves the process in our process group (Unix). By
+	// default it leads a group of its own, so stopping it reaches everything
+	// it started, but it no longer gets the terminal's Ctrl-C either: if we
+	// exit without stopping it (e.g. killed, or Ctrl-C not handled by
+	// cancelling Context), the group is orphaned and keeps running. With
+	// NoProcessGroup, StopSignal and kills reach only the process itself.
+	// It is ignored with PTY.
+	NoProcessGroup bool
+	// WaitDelay bounds how long Wait waits, once the process has exited, for
+	// its output to be closed and Stdin to stop being copied, as a background
+	// process it started may hold its output open and Stdin may block. Wait
+	// then returns exec.ErrWaitDelay, unless the process failed. It is 5s by
+	// default and never less than GracePeriod, as exec also kills the process
+	// if it is still running WaitDelay after Context is done.
+	WaitDelay time.Duration
+
+	result     *Result
+	stderrTail tailBuffer
+	stdinR     *stdinReader
+	scanErr    error
+
+	stdoutCap, stderrCap, combinedCap *captureBuffer
+	spillFiles                        []string
+
+	// exec copies output into these, and Stdin is copied to stdinW until stdinDone
+	stdoutW, stderrW *io.PipeWriter
+	stdinW           io.WriteCloser
+	stdinDone        chan struct{}
+
+	pty, ptySlave *os.File
+	winsize       [2]uint16
+
+	// set by Pipeline to connect stages directly, and to capture the stderr
+	// of stages whose stdout goes to the next
+	stdin         io.Reader
+	stdout        io.Writer
+	captureStderr bool
 }
 
 func NewSubprocess(bin string, args ...string) (*Subprocess, error) {
@@ -71,6 +116,36 @@ func NewSubprocess(bin string, args ...string) (*Subprocess, error) {
 	return subp, nil
 }
 
+// clone returns a Subprocess configured like sp, which hasn't been run.
+// New configuration fields must be added here.
+func (sp *Subprocess) clone() *Subprocess {
+	return &Subprocess{
+		WorkingDir:     sp.WorkingDir,
+		Bin:            sp.Bin,
+		Args:           slices.Clone(sp.Args),
+		Env:            maps.Clone(sp.Env),
+		Context:        sp.Context,
+		Scanner:        sp.Scanner,
+		Stdin:          sp.Stdin,
+		Nice:           sp.Nice,
+		Capture:        sp.Capture,
+		Print:          sp.Print,
+		CaptureConfig:  sp.CaptureConfig,
+		Label:          sp.Label,
+		Split:          sp.Split,
+		SplitFunc:      sp.SplitFunc,
+		MaxTokenSize:   sp.MaxTokenSize,
+		StdoutWriter:   sp.StdoutWriter,
+		StderrWriter:   sp.StderrWriter,
+		PTY:            sp.PTY,
+		StopSignal:     sp.StopSignal,
+		GracePeriod:    sp.GracePeriod,
+		WaitDelay:      sp.WaitDelay,
+		NoProcessGroup: sp.NoProcessGroup,
+		winsize:        sp.winsize,
+	}
+}
+
 func (sp *Subprocess) SetArgs(args ...string) {
 	sp.Args = args
 }
@@ -85,13 +160,16 @@ func (sp *Subprocess) Start(args ...string) (err error) {
 		sp.SetArgs(args...)
 	}
 	if sp.Context == nil {
-		sp.Context = context.Background() // TODO: make func in helper to incorporate max concurrency
+		sp.Context = context.Background()
 	}
 
 	sp.Done = make(chan struct{})
+	sp.Err = nil
 
 	sp.Cmd = exec.CommandContext(sp.Context, sp.Bin, sp.Args...)
 	sp.Cmd.Dir = sp.WorkingDir
+	sp.Cmd.Cancel = sp.terminate
+	sp.Cmd.WaitDelay = max(sp.waitDelay(), sp.gracePeriod())
 
 	if sp.Env != nil {
 		sp.Cmd.Env = SerializeMap(sp.Env)
@@ -102,37 +180,40 @@ func (sp *Subprocess) Start(args ...string) (err error) {
 	sp.Stdout.Reset()
 	sp.Stderr.Reset()
 	sp.Combined.Reset()
+	sp.result, sp.scanErr = nil, nil
+	sp.stdoutW, sp.stderrW, sp.stdinW, sp.stdinDone = nil, nil, nil, nil
+	sp.startCapture()
+	sp.stderrTail = tailBuffer{max: stderrTailSize}
 
-	sp.StdoutReader, err = sp.Cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	sp.StderrReader, err = sp.Cmd.StderrPipe()
-	if err != nil {
-		return err
+	if sp.PTY {
+		err = sp.setupPTY()
+	} else {
+		// the process leads its own group so stopping it reaches its children
+		// too; it no longer gets the terminal's Ctrl-C, so cancel Context instead
+		if !sp.NoProcessGroup {
+			setProcessGroup(sp.Cmd)
+		}
+		err = sp.setupPipes()
 	}
-
-	sp.stderrScanner = bufio.NewScanner(sp.StderrReader)
-	sp.stderrScanner.Split(bufio.ScanLines)
-
-	sp.stdoutScanner = bufio.NewScanner(sp.StdoutReader)
-	sp.stdoutScanner.Split(bufio.ScanLines)
-
-	sp.StdInWriter, err = sp.Cmd.StdinPipe()
 	if err != nil {
 		return err
 	}
 
 	err = sp.Cmd.Start()
+	if sp.PTY {
+		sp.startedPTY(err)
+	}
 	if err != nil {
-		sp.StdoutReader.Close()
-		sp.StderrReader.Close()
+		sp.closeOutput()
 		return err
 	}
+	if sp.stdinW != nil {
+		sp.copyStdin()
+	}
 
 	sp.Pid = sp.Cmd.Process.Pid
 
-	go sp.scanAndWait()
+	go sp.scanAndWait(time.Now())
 
 	if runtime.GOOS != "windows" && sp.Nice != 0 {
 		niceCmd := exec.Command("renice", "-n", Format("%d", sp.Nice), "-p", Format("%d", sp.Pid))
@@ -141,6 +222,53 @@ func (sp *Subprocess) Start(args ...string) (err error) {
 	return nil
 }
 
+// setupPipes connects the process's stdin, stdout and stderr.
+func (sp *Subprocess) setupPipes() (err error) {
+	sp.StdoutReader, sp.stdoutScanner = nil, nil
+	switch {
+	case sp.stdout != nil:
+		sp.Cmd.Stdout = sp.stdout
+	case sp.StdoutWriter != nil:
+		sp.Cmd.Stdout = sp.StdoutWriter
+	default:
+		// exec copies the output in, so WaitDelay applies to it
+		var pr *io.PipeReader
+		pr, sp.stdoutW = io.Pipe()
+		sp.Cmd.Stdout, sp.StdoutReader = sp.stdoutW, pr
+		sp.stdoutScanner = sp.newScanner(pr)
+	}
+
+	sp.StderrReader, sp.stderrScanner = nil, nil
+	if sp.StderrWriter != nil {
+		sp.Cmd.Stderr = io.MultiWriter(sp.StderrWriter, &sp.stderrTail)
+	} else {
+		var pr *io.PipeReader
+		pr, sp.stderrW = io.Pipe()
+		sp.Cmd.Stderr, sp.StderrReader = sp.stderrW, pr
+		sp.stderrScanner = sp.newScanner(pr)
+	}
+
+	sp.StdInWriter, sp.stdinR = nil, nil
+	switch {
+	case sp.stdin != nil:
+		sp.Cmd.Stdin = sp.stdin
+	case sp.Stdin != nil:
+		// copied by copyStdin rather than exec, whose Wait would wait for a
+		// blocked Read of Stdin
+		sp.stdinR = &stdinReader{r: sp.Stdin}
+		sp.stdinW, err = sp.Cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+	default:
+		sp.StdInWriter, err = sp.Cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (sp *Subprocess) String() string {
 	parts := []string{sp.Bin}
 	for _, a := range sp.Args {
@@ -152,65 +280,147 @@ func (sp *Subprocess) String() string {
 	return strings.Join(parts, " ")
 }
 
-func (sp *Subprocess) scanAndWait() {
-	exitCh := make(chan bool)
-	label := sp.Label.Render()
-
-	// stderr
+func (sp *Subprocess) scanAndWait(started time.Time) {
+	scanned := make(chan struct{})
 	go func() {
-		for sp.stderrScanner.Scan() {
-			line := sp.stderrScanner.Text()
-			sp.mu.Lock()
-			if sp.Capture {
-				sp.Stderr.WriteString(line + "\n")
-				sp.Combined.WriteString(line + "\n")
-			}
-			if sp.Scanner != nil {
-				sp.Scanner(true, line)
-			}
-			if sp.Print {
-				if label != "" {
-					line = Format("%s | %s", Colorize(90, label), line)
-				}
-				fmt.Fprintf(os.Stderr, "%s", line+"\n")
+		defer close(scanned)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			sp.scan(true, sp.stderrScanner, sp.StderrReader)
+		}()
+		go func() {
+			defer wg.Done()
+			sp.scan(false, sp.stdoutScanner, sp.StdoutReader)
+		}()
+		wg.Wait()
+	}()
+
+	// exec waits for the output it copies to be read
+	err := sp.Cmd.Wait()
+	sp.closeOutput()
+	if sp.PTY && !sp.await(scanned) && err == nil {
+		// nothing but the process's children can close the terminal
+		err = exec.ErrWaitDelay
+	}
+	sp.closePTY()
+	<-scanned
+
+	if sp.stdinDone != nil {
+		// Stdin may be blocked in Read; leave it be
+		sp.await(sp.stdinDone)
+	}
+	if f, ok := sp.Stdin.(feed); ok {
+		f.reset()
+	}
+	if err == nil {
+		err = sp.scanErr
+	}
+	if cerr := sp.finishCapture(); err == nil {
+		err = cerr
+	}
+	var stdinErr error
+	if sp.stdinR != nil {
+		if rerr := sp.stdinR.Err(); rerr != nil {
+			stdinErr = fmt.Errorf("stdin: %w", rerr)
+			// Wait returns the bare read error if the process succeeded
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				err = stdinErr
 			}
-			sp.mu.Unlock()
 		}
-		exitCh <- true
-	}()
+	}
+	if err != nil {
+		sp.Err = err
+	}
+	sp.result = sp.newResult(started)
+	sp.result.StdinErr = stdinErr
 
-	// stdout
+	close(sp.Done)
+}
+
+// await waits up to WaitDelay for done to be closed, reporting whether it was.
+func (sp *Subprocess) await(done <-chan struct{}) bool {
+	t := time.NewTimer(sp.Cmd.WaitDelay)
+	defer t.Stop()
+	select {
+	case <-done:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// closeOutput ends the scanned output streams once exec has copied all of it.
+func (sp *Subprocess) closeOutput() {
+	for _, w := range []*io.PipeWriter{sp.stdoutW, sp.stderrW} {
+		if w != nil {
+			w.Close()
+		}
+	}
+}
+
+// copyStdin copies Stdin to the process in the background, closing its
+// stdin at EOF or on error, as exec does.
+func (sp *Subprocess) copyStdin() {
+	w, done := sp.stdinW, make(chan struct{})
+	sp.stdinDone = done
 	go func() {
-		for sp.stdoutScanner.Scan() {
-			line := sp.stdoutScanner.Text()
-			sp.mu.Lock()
-			if sp.Capture {
-				sp.Stdout.WriteString(line + "\n")
-				sp.Combined.WriteString(line + "\n")
-			}
-			if sp.Scanner != nil {
-				sp.Scanner(false, line)
-			}
-			if sp.Print {
+		defer close(done)
+		_, _ = io.Copy(w, sp.stdinR)
+		_ = w.Close()
+	}()
+}
+
+// scan handles the tokens of one output stream until it ends. It returns
+// straight away if the stream isn't being scanned.
+func (sp *Subprocess) scan(stderr bool, sc *bufio.Scanner, r io.Reader) {
+	if sc == nil {
+		return
+	}
+
+	out := os.Stdout
+	if stderr {
+		out = os.Stderr
+	}
+	label := sp.Label.Render()
+	term := sp.terminator()
+
+	for sc.Scan() {
+		token := sc.Text()
+		sp.mu.Lock()
+		if stderr {
+			sp.stderrTail.Write([]byte(token + term))
+		}
+		if sp.Capture || sp.captureStderr && stderr {
+			sp.capture(stderr, token+term)
+		}
+		if sp.Scanner != nil {
+			sp.Scanner(stderr, token)
+		}
+		if sp.Print {
+			line := token
+			if term != "" {
 				if label != "" {
 					line = Format("%s | %s", Colorize(90, label), line)
 				}
-				fmt.Fprintf(os.Stdout, "%s", line+"\n")
+				line += "\n"
 			}
-			sp.mu.Unlock()
+			fmt.Fprint(out, line)
 		}
-		exitCh <- true
-	}()
-
-	err := sp.Cmd.Wait()
-	if err != nil {
-		sp.Err = err
+		sp.mu.Unlock()
 	}
 
-	<-exitCh
-	<-exitCh
-
-	sp.Done <- struct{}{}
+	if err := sc.Err(); err != nil {
+		// keep reading so the process doesn't block on a full pipe
+		_, _ = io.Copy(io.Discard, r)
+		sp.mu.Lock()
+		if sp.scanErr == nil {
+			sp.scanErr = fmt.Errorf("%s: %w", If(stderr, "stderr", "stdout"), err)
+		}
+		sp.mu.Unlock()
+	}
 }
 
 func (sp *Subprocess) Run(args ...string) error {
@@ -225,27 +435,99 @@ func (sp *Subprocess) Run(args ...string) error {
 	return nil
 }
 
+// Wait waits for the process to exit and its output to be read.
+// It may be called more than once. If the process failed, the error is an
+// *ExitError describing how.
 func (sp *Subprocess) Wait() error {
+	<-sp.Done
+
+	if sp.Err != nil {
+		return &ExitError{Result: sp.result, Err: sp.Err}
+	}
+	if sp.result != nil && sp.result.ExitCode > 0 {
+		return &ExitError{Result: sp.result, Err: fmt.Errorf("exit code: %d", sp.result.ExitCode)}
+	}
+	return nil
+}
+
+// Stop sends StopSignal to the process group, kills it if it is still
+// running after GracePeriod, and returns once the process has exited.
+func (sp *Subprocess) Stop() error {
+	if sp.Cmd == nil || sp.Cmd.Process == nil {
+		return errors.New("subprocess not started")
+	}
 	select {
 	case <-sp.Done:
-	case <-sp.Context.Done():
-		sp.Cmd.Process.Signal(syscall.SIGINT)
-		t := time.NewTimer(5 * time.Second)
+		return nil
+	default:
+	}
+
+	if err := sp.terminate(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	<-sp.Done
+	return nil
+}
+
+// SetWindowSize sets the size of a PTY process's terminal, straight away
+// if it is running and otherwise once it starts.
+func (sp *Subprocess) SetWindowSize(rows, cols uint16) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.winsize = [2]uint16{rows, cols}
+	if sp.pty == nil {
+		return nil
+	}
+	return setWinsize(sp.pty, rows, cols)
+}
+
+// terminate signals the process group to stop and arranges for it to be
+// killed after the grace period. It is also the Cmd's Cancel func.
+func (sp *Subprocess) terminate() error {
+	proc, done := sp.Cmd.Process, sp.Done
+
+	sig := sp.StopSignal
+	if sig == nil {
+		sig = os.Interrupt
+	}
+	grace := sp.gracePeriod()
+
+	if err := sp.signal(proc, sig); err != nil {
+		return err
+	}
+	go func() {
+		t := time.NewTimer(grace)
+		defer t.Stop()
 		select {
-		case <-sp.Done:
+		case <-done:
 		case <-t.C:
-			log.Fatal(sp.Cmd.Process.Kill())
+			_ = sp.signal(proc, os.Kill)
 		}
+	}()
+	return nil
+}
+
+// signal sends sig to the process group led by p, or to p alone with NoProcessGroup.
+func (sp *Subprocess) signal(p *os.Process, sig os.Signal) error {
+	if sp.NoProcessGroup && !sp.PTY {
+		return signalProcess(p, sig)
 	}
-	if sp.Err != nil {
-		return sp.Err
+	return signalGroup(p, sig)
+}
+
+func (sp *Subprocess) gracePeriod() time.Duration {
+	if sp.GracePeriod <= 0 {
+		return 5 * time.Second
 	}
-	if sp.Cmd != nil && sp.Cmd.ProcessState != nil {
-		if code := sp.Cmd.ProcessState.ExitCode(); code != 0 {
-			return fmt.Errorf("exit code: %d", code)
-		}
+	return sp.GracePeriod
+}
+
+func (sp *Subprocess) waitDelay() time.Duration {
+	if sp.WaitDelay <= 0 {
+		return 5 * time.Second
 	}
-	return nil
+	return sp.WaitDelay
 }
 
 type Label struct {
@@ -0,0 +1,270 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Task is a node of a TaskGraph: a command or a Go func, run once all of its
+// dependencies have succeeded.
+type Task struct {
+	Name string
+	Deps []string
+	Cmd  *Subprocess
+	Func func(ctx context.Context) error
+}
+
+func (t *Task) String() string {
+	if t.Cmd != nil {
+		return t.Cmd.String()
+	}
+	return "func"
+}
+
+type TaskStatus int
+
+const (
+	TaskPending TaskStatus = iota
+	TaskSucceeded
+	TaskFailed
+	// TaskSkipped means a dependency failed or was skipped, or the graph was
+	// cancelled, in which case the result's Err is the context's error.
+	TaskSkipped
+)
+
+func (s TaskStatus) String() string {
+	switch s {
+	case TaskSucceeded:
+		return "succeeded"
+	case TaskFailed:
+		return "failed"
+	case TaskSkipped:
+		return "skipped"
+	}
+	return "pending"
+}
+
+type TaskResult struct {
+	Name   string
+	Status TaskStatus
+	// Cmd is the copy of the task's Subprocess that was run, holding its
+	// output. It is nil for Func tasks and tasks that never ran.
+	Cmd      *Subprocess
+	Err      error
+	Duration time.Duration
+}
+
+// TaskGraph runs tasks in dependency order, like make, running independent
+// tasks in parallel.
+type TaskGraph struct {
+	// Concurrency is the number of tasks run at once, runtime.NumCPU() by default.
+	Concurrency int
+	// DryRun prints the plan to Out instead of running anything.
+	DryRun bool
+	// Out is where DryRun prints the plan, os.Stdout by default.
+	Out io.Writer
+
+	tasks map[string]*Task
+	order []string
+}
+
+func NewTaskGraph() *TaskGraph {
+	return &TaskGraph{tasks: make(map[string]*Task)}
+}
+
+func (tg *TaskGraph) Add(t Task) error {
+	switch {
+	case t.Name == "":
+		return errors.New("task without a name")
+	case (t.Cmd == nil) == (t.Func == nil):
+		return fmt.Errorf("task '%s' needs exactly one of Cmd and Func", t.Name)
+	}
+	if _, ok := tg.tasks[t.Name]; ok {
+		return fmt.Errorf("duplicate task '%s'", t.Name)
+	}
+	if tg.tasks == nil {
+		tg.tasks = make(map[string]*Task)
+	}
+	tg.tasks[t.Name] = &t
+	tg.order = append(tg.order, t.Name)
+	return nil
+}
+
+// Command adds a task running sp after deps.
+func (tg *TaskGraph) Command(name string, sp *Subprocess, deps ...string) error {
+	return tg.Add(Task{Name: name, Cmd: sp, Deps: deps})
+}
+
+// Func adds a task calling fn after deps.
+func (tg *TaskGraph) Func(name string, fn func(ctx context.Context) error, deps ...string) error {
+	return tg.Add(Task{Name: name, Func: fn, Deps: deps})
+}
+
+func (tg *TaskGraph) graph() (*Graph[string], error) {
+	g := NewGraph[string]()
+	for _, name := range tg.order {
+		g.AddNode(name)
+		for _, dep := range tg.tasks[name].Deps {
+			if _, ok := tg.tasks[dep]; !ok {
+				return nil, fmt.Errorf("task '%s' depends on unknown task '%s'", name, dep)
+			}
+			g.AddEdge(dep, name)
+		}
+	}
+	return g, nil
+}
+
+// Plan returns the task names in the order they would run without parallelism.
+func (tg *TaskGraph) Plan() ([]string, error) {
+	g, err := tg.graph()
+	if err != nil {
+		return nil, err
+	}
+	order, err := TopoSort(g)
+	if err != nil {
+		return nil, fmt.Errorf("tasks: %w", err)
+	}
+	return order, nil
+}
+
+func (tg *TaskGraph) printPlan(order []string) error {
+	out := tg.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	for i, name := range order {
+		t := tg.tasks[name]
+		line := Format("%d. %s: %s", i+1, name, t)
+		if len(t.Deps) > 0 {
+			line += " (after " + strings.Join(t.Deps, ", ") + ")"
+		}
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run runs every task once its dependencies have succeeded, skipping those
+// downstream of a failure, and returns the results in the order tasks were
+// added. The error joins those of the failed tasks. Cancelling ctx stops
+// running tasks and skips the rest, which report the context's error. Each
+// command task runs a copy of its Subprocess, so one Subprocess may be used
+// by several tasks.
+func (tg *TaskGraph) Run(ctx context.Context) ([]TaskResult, error) {
+	order, err := tg.Plan()
+	if err != nil {
+		return nil, err
+	}
+	if tg.DryRun {
+		return nil, tg.printPlan(order)
+	}
+
+	g, _ := tg.graph()
+	conc := tg.Concurrency
+	if conc <= 0 {
+		conc = runtime.NumCPU()
+	}
+
+	results := make(map[string]*TaskResult, len(order))
+	waiting := make(map[string]int, len(order))
+	for _, name := range order {
+		for _, next := range g.Neighbors(name) {
+			waiting[next]++
+		}
+	}
+	var ready []string
+	for _, name := range order {
+		results[name] = &TaskResult{Name: name}
+		if waiting[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	done := make(chan TaskResult)
+	running, finished := 0, 0
+	finish := func(res TaskResult) {
+		*results[res.Name] = res
+		finished++
+		for _, next := range g.Neighbors(res.Name) {
+			if waiting[next]--; waiting[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	for finished < len(order) {
+		for len(ready) > 0 && running < conc {
+			name := ready[0]
+			ready = ready[1:]
+
+			t := tg.tasks[name]
+			if err := ctx.Err(); err != nil {
+				finish(TaskResult{Name: name, Status: TaskSkipped, Err: err})
+				continue
+			}
+			if tg.blocked(t, results) {
+				finish(TaskResult{Name: name, Status: TaskSkipped})
+				continue
+			}
+
+			running++
+			go func() {
+				start := time.Now()
+				cmd, err := tg.runTask(ctx, t)
+				done <- TaskResult{
+					Name:     name,
+					Status:   If(err == nil, TaskSucceeded, TaskFailed),
+					Cmd:      cmd,
+					Err:      err,
+					Duration: time.Since(start),
+				}
+			}()
+		}
+		if running == 0 {
+			// everything left was skipped
+			continue
+		}
+
+		res := <-done
+		running--
+		finish(res)
+	}
+
+	ret := make([]TaskResult, 0, len(order))
+	var errs []error
+	for _, name := range tg.order {
+		res := results[name]
+		ret = append(ret, *res)
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, res.Err))
+		}
+	}
+	return ret, errors.Join(errs...)
+}
+
+// blocked reports whether a dependency of t didn't succeed.
+func (tg *TaskGraph) blocked(t *Task, results map[string]*TaskResult) bool {
+	for _, dep := range t.Deps {
+		if results[dep].Status != TaskSucceeded {
+			return true
+		}
+	}
+	return false
+}
+
+// runTask runs t, returning the copy of its Subprocess that ran, if any.
+func (tg *TaskGraph) runTask(ctx context.Context, t *Task) (*Subprocess, error) {
+	if t.Func != nil {
+		return nil, t.Func(ctx)
+	}
+	sp := t.Cmd.clone()
+	sp.Context = ctx
+	return sp, sp.Run()
+}
@@ -0,0 +1,129 @@
+package p
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopoSort(t *testing.T) {
+	g := NewGraph[string]()
+	g.AddEdge("extract", "load")
+	g.AddEdge("extract", "validate")
+	g.AddEdge("validate", "load")
+	g.AddNode("notify")
+
+	order, err := TopoSort(g)
+	require.NoError(t, err)
+	require.Equal(t, []string{"extract", "notify", "validate", "load"}, order)
+	require.Equal(t, []string{"extract", "load", "validate"}, BFS(g, "extract"))
+
+	g.AddEdge("load", "transform")
+	g.AddEdge("transform", "validate")
+	_, err = TopoSort(g)
+	var ce *CycleError[string]
+	require.True(t, errors.As(err, &ce))
+	require.Equal(t, "cycle: validate -> load -> transform -> validate", err.Error())
+}
+
+func TestTaskGraph(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		ran []string
+	)
+	record := func(name string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			ran = append(ran, name)
+			mu.Unlock()
+			return err
+		}
+	}
+
+	tg := NewTaskGraph()
+	tg.Concurrency = 2
+	require.NoError(t, tg.Func("extract", record("extract", nil)))
+	require.NoError(t, tg.Command("validate", newJob(t, "sh", "-c", "exit 1"), "extract"))
+	require.NoError(t, tg.Func("load", record("load", nil), "validate", "extract"))
+	require.NoError(t, tg.Func("report", record("report", nil), "load"))
+	require.NoError(t, tg.Func("archive", record("archive", nil), "extract"))
+	require.Error(t, tg.Func("archive", record("archive", nil)))
+
+	results, err := tg.Run(context.Background())
+	require.ErrorContains(t, err, "validate: exit status 1")
+	require.ElementsMatch(t, []string{"extract", "archive"}, ran)
+
+	status := make(map[string]TaskStatus)
+	for _, res := range results {
+		status[res.Name] = res.Status
+	}
+	require.Equal(t, map[string]TaskStatus{
+		"extract":  TaskSucceeded,
+		"validate": TaskFailed,
+		"load":     TaskSkipped,
+		"report":   TaskSkipped,
+		"archive":  TaskSucceeded,
+	}, status)
+}
+
+func TestTaskGraph_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tg := NewTaskGraph()
+	require.NoError(t, tg.Func("extract", func(context.Context) error {
+		t.Fatal("ran after cancel")
+		return nil
+	}))
+	results, err := tg.Run(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, TaskSkipped, results[0].Status)
+	require.ErrorIs(t, results[0].Err, context.Canceled)
+}
+
+func TestTaskGraph_SharedCmd(t *testing.T) {
+	sp := newJob(t, "sh", "-c", "echo $0", "shared")
+	sp.Capture = true
+
+	tg := NewTaskGraph()
+	tg.Concurrency = 2
+	require.NoError(t, tg.Command("a", sp))
+	require.NoError(t, tg.Command("b", sp))
+	results, err := tg.Run(context.Background())
+	require.NoError(t, err)
+	for _, res := range results {
+		require.NotSame(t, sp, res.Cmd)
+		require.Equal(t, "shared\n", res.Cmd.Stdout.String())
+	}
+	require.Nil(t, sp.Cmd)
+}
+
+func TestTaskGraph_DryRunAndErrors(t *testing.T) {
+	var out bytes.Buffer
+	tg := NewTaskGraph()
+	tg.DryRun = true
+	tg.Out = &out
+	require.NoError(t, tg.Command("fetch", newJob(t, "echo", "fetch"), "clean"))
+	require.NoError(t, tg.Func("clean", func(context.Context) error {
+		t.Fatal("ran during dry run")
+		return nil
+	}))
+
+	_, err := tg.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "1. clean: func\n2. fetch: echo fetch (after clean)\n", out.String())
+
+	require.NoError(t, tg.Func("a", func(context.Context) error { return nil }, "b"))
+	require.NoError(t, tg.Func("b", func(context.Context) error { return nil }, "a"))
+	_, err = tg.Run(context.Background())
+	require.EqualError(t, err, "tasks: cycle: a -> b -> a")
+
+	tg = NewTaskGraph()
+	require.NoError(t, tg.Func("a", func(context.Context) error { return nil }, "missing"))
+	_, err = tg.Run(context.Background())
+	require.EqualError(t, err, "task 'a' depends on unknown task 'missing'")
+}
@@ -0,0 +1,149 @@
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TraceContext identifies the trace and span a request belongs to.
+type TraceContext struct {
+	TraceID string
+	// SpanID is 16 hex characters, as expected by Cloud Logging.
+	SpanID  string
+	Sampled bool
+}
+
+// ParseTraceContext reads the W3C traceparent header, falling back to
+// X-Cloud-Trace-Context. It returns false if neither is present and valid.
+func ParseTraceContext(h http.Header) (TraceContext, bool) {
+	if tc, ok := parseTraceparent(h.Get("traceparent")); ok {
+		return tc, true
+	}
+	return parseCloudTraceContext(h.Get("X-Cloud-Trace-Context"))
+}
+
+// traceparent: "00-<32 hex trace id>-<16 hex span id>-<2 hex flags>"
+func parseTraceparent(v string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return TraceContext{}, false
+	}
+	if len(parts[1]) != 32 || !isHex(parts[1]) || strings.Trim(parts[1], "0") == "" {
+		return TraceContext{}, false
+	}
+	if len(parts[2]) != 16 || !isHex(parts[2]) || strings.Trim(parts[2], "0") == "" {
+		return TraceContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || len(parts[3]) != 2 {
+		return TraceContext{}, false
+	}
+
+	return TraceContext{
+		TraceID: strings.ToLower(parts[1]),
+		SpanID:  strings.ToLower(parts[2]),
+		Sampled: flags&1 == 1,
+	}, true
+}
+
+// X-Cloud-Trace-Context: "<trace id>/<decimal span id>;o=<0|1>"
+func parseCloudTraceContext(v string) (TraceContext, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return TraceContext{}, false
+	}
+
+	var tc TraceContext
+	v, opts, _ := strings.Cut(v, ";")
+	tc.TraceID, v, _ = strings.Cut(v, "/")
+	if !isHex(tc.TraceID) {
+		return TraceContext{}, false
+	}
+	if span, err := strconv.ParseUint(v, 10, 64); err == nil && span != 0 {
+		tc.SpanID = Format("%016x", span)
+	}
+	tc.Sampled = strings.TrimSpace(opts) == "o=1"
+
+	return tc, true
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// TraceName returns the resource name Cloud Logging correlates on,
+// i.e. "projects/<projectID>/traces/<traceID>".
+func TraceName(projectID, traceID string) string {
+	if projectID == "" {
+		return traceID
+	}
+	return "projects/" + projectID + "/traces/" + traceID
+}
+
+// WithTrace returns a logger that attaches tc to every entry.
+func (l *GcpLogger) WithTrace(projectID string, tc TraceContext) *GcpLogger {
+	c := l.clone()
+	c.trace, _ = json.Marshal(TraceName(projectID, tc.TraceID))
+	c.spanID = tc.SpanID
+	c.sampled = tc.Sampled
+	return c
+}
+
+type loggerKey struct{}
+
+var defaultLogger = &GcpLogger{}
+
+func ContextWithLogger(ctx context.Context, l *GcpLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger stored in ctx by TraceMiddleware or ContextWithLogger.
+// If there is none, a logger writing to stdout/stderr is returned.
+func FromContext(ctx context.Context) *GcpLogger {
+	if l, ok := loggerFromContext(ctx); ok {
+		return l
+	}
+	return defaultLogger
+}
+
+func loggerFromContext(ctx context.Context) (*GcpLogger, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	l, ok := ctx.Value(loggerKey{}).(*GcpLogger)
+	return l, ok && l != nil
+}
+
+// TraceMiddleware stores a request-scoped logger in the request context,
+// correlated with the request's trace (see FromContext). If projectID is
+// empty, GOOGLE_CLOUD_PROJECT is used. A nil l writes to stdout/stderr.
+func TraceMiddleware(projectID string, l *GcpLogger) func(http.Handler) http.Handler {
+	if projectID == "" {
+		projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if l == nil {
+		l = defaultLogger
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rl := l
+			if tc, ok := ParseTraceContext(r.Header); ok {
+				rl = l.WithTrace(projectID, tc)
+			}
+			next.ServeHTTP(w, r.WithContext(ContextWithLogger(r.Context(), rl)))
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package p
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   TraceContext
+		ok     bool
+	}{
+		{
+			"traceparent",
+			map[string]string{"traceparent": "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+			TraceContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+			true,
+		},
+		{
+			"cloud trace context",
+			map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/74;o=1"},
+			TraceContext{"105445aa7843bc8bf206b12000100000", "000000000000004a", true},
+			true,
+		},
+		{
+			"cloud trace context without span",
+			map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000"},
+			TraceContext{TraceID: "105445aa7843bc8bf206b12000100000"},
+			true,
+		},
+		{
+			"traceparent preferred",
+			map[string]string{
+				"traceparent":           "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+				"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/74;o=1",
+			},
+			TraceContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false},
+			true,
+		},
+		{
+			"invalid traceparent falls back",
+			map[string]string{
+				"traceparent":           "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+				"X-Cloud-Trace-Context": "abc/1",
+			},
+			TraceContext{TraceID: "abc", SpanID: "0000000000000001"},
+			true,
+		},
+		{"none", map[string]string{}, TraceContext{}, false},
+		{"garbage", map[string]string{"X-Cloud-Trace-Context": "not-a-trace"}, TraceContext{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+			got, ok := ParseTraceContext(h)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTraceMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	base := NewGcpLogger(&out, &out)
+
+	h := TraceMiddleware("my-proj", base)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handled")
+		slog.New(NewSlogHandler(base, nil)).InfoContext(r.Context(), "via slog")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/74;o=1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	got := decodeLines(t, &out)
+	require.Len(t, got, 2)
+	for _, e := range got {
+		require.Equal(t, "projects/my-proj/traces/105445aa7843bc8bf206b12000100000", e["logging.googleapis.com/trace"])
+		require.Equal(t, "000000000000004a", e["logging.googleapis.com/spanId"])
+		require.Equal(t, true, e["logging.googleapis.com/trace_sampled"])
+	}
+
+	require.Same(t, defaultLogger, FromContext(context.Background()))
+}
@@ -0,0 +1,109 @@
+package p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// WebhookStyle is the JSON payload a WebhookNotifier posts.
+type WebhookStyle int
+
+const (
+	// WebhookGeneric posts the Notification itself.
+	WebhookGeneric WebhookStyle = iota
+	// WebhookSlack posts a Slack incoming webhook message.
+	WebhookSlack
+	// WebhookTeams posts a Microsoft Teams MessageCard.
+	WebhookTeams
+	// WebhookChat posts a Google Chat message.
+	WebhookChat
+)
+
+// WebhookNotifier posts notifications as JSON to URL.
+type WebhookNotifier struct {
+	URL    string
+	Style  WebhookStyle
+	Header http.Header
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(w.payload(n))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := Coalesce(w.Client, http.DefaultClient)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (w *WebhookNotifier) payload(n Notification) any {
+	title := n.Subject
+	if name, ok := n.Severity.name(); ok {
+		title = name + ": " + title
+	}
+
+	switch w.Style {
+	case WebhookSlack, WebhookChat:
+		// both render *bold* in plain text messages
+		return map[string]any{"text": "*" + title + "*\n" + n.Text()}
+	case WebhookTeams:
+		facts := make([]map[string]string, 0, len(n.Fields))
+		for _, k := range sortedKeys(n.Fields) {
+			facts = append(facts, map[string]string{"name": k, "value": consoleValue(n.Fields[k])})
+		}
+		card := map[string]any{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    title,
+			"title":      title,
+			"themeColor": webhookColor(n.Severity),
+		}
+		if n.Body != "" {
+			card["text"] = n.Body
+		}
+		if len(facts) > 0 {
+			card["sections"] = []map[string]any{{"facts": facts}}
+		}
+		return card
+	default:
+		return n
+	}
+}
+
+func webhookColor(s Severity) string {
+	switch {
+	case s >= Error:
+		return "D32F2F"
+	case s >= Warning:
+		return "F9A825"
+	default:
+		return "1976D2"
+	}
+}